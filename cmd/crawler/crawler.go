@@ -1,11 +1,20 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"nsfw/internal/config"
 	"nsfw/internal/crawler"
+	"nsfw/internal/output"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
-	"time"
+	"strings"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 )
@@ -20,111 +29,213 @@ func init() {
 }
 
 func main() {
+	os.Exit(run())
+}
+
+/* Private stuffs */
+
+// run crawls with the settings of the command line, and returns the exit code:
+// `0` once the crawl is over, `1` if it was aborted or failed
+func run() int {
 	defer func() {
 		logrus.
 			WithFields(logrus.Fields{"goroutines": runtime.NumGoroutine()}).
 			Info("Gracefully shutting down")
 	}()
 
-	switch os.Getenv("SOURCE") {
-	case "instagram":
-		crawlInstagram()
-	default:
-		crawlDummy()
+	settings, err := config.Load(os.Args[0], os.Args[1:], os.Getenv)
+
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+
+	panicOnError(err)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stats, err := crawl(ctx, settings)
+	pending := stats.Pending
+	stats.Pending = nil
+
+	logrus.WithField("stats", fmt.Sprintf("%+v", stats)).Info("crawling finished")
+
+	if len(pending) > 0 {
+		logrus.WithField("pending", len(pending)).Warn("profiles left uncrawled, run with -resume to continue")
 	}
+
+	if err != nil {
+		logrus.WithField("error", err).Error("crawling stopped")
+		return 1
+	}
+
+	return 0
 }
 
-/* Private stuffs */
+// openOutput creates the output file, or appends to it when resuming a crawl
+func openOutput(path string, resume bool) *os.File {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+
+	if resume {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
 
-type crawlerWriter struct {
-	file   *os.File
-	writer *csv.Writer
+	file, err := os.OpenFile(path, flags, 0o644)
+	panicOnError(err)
+
+	return file
 }
 
-func newCrawlerWriter() *crawlerWriter {
-	file, err := os.Create("results.csv")
+func fileSize(file *os.File) int64 {
+	info, err := file.Stat()
 	panicOnError(err)
 
-	writer := csv.NewWriter(file)
+	return info.Size()
+}
 
-	return &crawlerWriter{
-		file:   file,
-		writer: writer,
+// newMultiWriter creates the writers of all outputs, applying their error policy.
+// The crawler flushes and closes them, along with their files, once the crawl is over.
+func newMultiWriter(settings config.Config) *output.MultiWriter {
+	sinks := []output.Sink{}
+
+	for _, out := range settings.Outputs {
+		sinks = append(sinks, output.Sink{
+			Name:    out.Path,
+			Writer:  newWriter(settings, out),
+			Policy:  out.OnError,
+			Written: writtenIDs(settings, out),
+		})
 	}
+
+	return output.NewMultiWriter(sinks...)
 }
 
-func (w *crawlerWriter) Write(profile crawler.Profile) error {
-	logrus.WithField("profile", profile).Info("  - writing")
+// writtenIDs reads the profiles already in an output appended by a resumed crawl, so that they aren't written again.
+// Databases upsert profiles, and graph outputs are never resumed.
+func writtenIDs(settings config.Config, out config.Output) map[string]struct{} {
+	if !settings.Resume || out.IsPostgres() {
+		return nil
+	}
 
-	row := []string{
-		profile.ID,
-		profile.Username,
-		profile.DisplayName,
-		profile.AvatarURL,
+	if _, ok := output.GraphFormatOf(out.Path); ok {
+		return nil
 	}
 
-	row = append(row, profile.Gallery...)
-	return w.writer.Write(row)
-}
+	read := func(r io.Reader) (map[string]struct{}, error) {
+		return output.ReadCSVIDs(r, settings.CSV.Delimiter)
+	}
 
-func (w *crawlerWriter) Flush() error {
-	w.writer.Flush()
-	err := w.file.Close()
+	switch strings.ToLower(filepath.Ext(out.Path)) {
+	case ".db", ".sqlite", ".sqlite3":
+		return nil
 
-	if err != nil {
-		logrus.WithField("error", err).Error("flushing writer failed")
+	case ".jsonl", ".ndjson":
+		read = output.ReadJSONLinesIDs
 	}
 
-	return err
+	file, err := os.Open(out.Path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	panicOnError(err)
+	defer file.Close()
+
+	ids, err := read(file)
+	panicOnError(err)
+
+	if len(ids) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"output":   out.Path,
+			"profiles": len(ids),
+		}).Info("profiles already written are skipped")
+	}
+
+	return ids
 }
 
-func crawlInstagram() {
-	writer := newCrawlerWriter()
-	defer writer.Flush()
+// newWriter creates the writer picked by the scheme or the extension of the output, CSV by default
+func newWriter(settings config.Config, out config.Output) crawler.Writer {
+	if out.IsPostgres() {
+		postgresConfig := output.PostgresConfig{
+			DSN:           out.Path,
+			BatchSize:     settings.Postgres.BatchSize,
+			FlushInterval: settings.Postgres.FlushInterval,
+			MaxOpenConns:  settings.Postgres.MaxConns,
+		}
+
+		db, err := output.OpenPostgres(context.Background(), postgresConfig)
+		panicOnError(err)
+
+		return output.NewPostgresWriter(db, postgresConfig)
+	}
+
+	switch strings.ToLower(filepath.Ext(out.Path)) {
+	case ".db", ".sqlite", ".sqlite3":
+		writer, err := output.NewSQLiteWriter(out.Path)
+		panicOnError(err)
 
-	// TODO: read from somewhere else
-	seedInstagramProfile := crawler.Profile{
-		ID:       "3030197091",
-		Username: "vox.ngoc.traan",
+		return writer
+
+	case ".jsonl", ".ndjson":
+		return output.NewJSONLinesWriter(openOutput(out.Path, settings.Resume))
 	}
 
-	config := crawler.Config{
-		Seed:      seedInstagramProfile,
-		SessionID: "48056993126:dM0qI5smuIlzte:18",
-		Writer:    writer,
+	// Graphs are whole documents rewritten by every run, config validation refuses to resume them
+	if format, ok := output.GraphFormatOf(out.Path); ok {
+		writer, err := output.NewGraphWriter(openOutput(out.Path, false), format)
+		panicOnError(err)
+
+		return writer
 	}
 
-	limiterConfig := crawler.LimiterConfig{
-		DeferTime:  time.Second,
-		MaxTakes:   10,
-		MaxWorkers: 1,
+	file := openOutput(out.Path, settings.Resume)
+
+	// The header rows are already there when a resumed crawl appends to an existing output
+	csvConfig := output.CSVConfig{
+		Delimiter:  settings.CSV.Delimiter,
+		SkipHeader: settings.Resume && fileSize(file) > 0,
 	}
 
-	instagramCrawler, err := crawler.NewInstagramCrawler(config, limiterConfig)
-	panicOnError(err)
+	if settings.CSV.GalleryFile != "" {
+		gallery := openOutput(settings.CSV.GalleryFile, settings.Resume)
+		csvConfig.Gallery = gallery
+		csvConfig.SkipGalleryHeader = settings.Resume && fileSize(gallery) > 0
+	}
 
-	instagramCrawler.Run()
+	return output.NewCSVWriter(file, csvConfig)
 }
 
-func crawlDummy() {
-	writer := newCrawlerWriter()
-	defer writer.Flush()
+func crawl(ctx context.Context, settings config.Config) (crawler.RunStats, error) {
+	crawlerConfig, err := settings.CrawlerConfig()
+	panicOnError(err)
+
+	crawlerConfig.Writer = newMultiWriter(settings)
+
+	if settings.Checkpoint != "" {
+		checkpoint, err := crawler.NewFileCheckpoint(settings.Checkpoint)
+		panicOnError(err)
+		defer checkpoint.Close()
 
-	config := crawler.Config{
-		Seed:   crawler.Profile{ID: "1"},
-		Writer: writer,
+		crawlerConfig.Checkpoint = checkpoint
 	}
 
-	limiterConfig := crawler.LimiterConfig{
-		DeferTime:  200 * time.Millisecond,
-		MaxTakes:   10,
-		MaxWorkers: 1,
+	newCrawler := crawler.NewDummyCrawler
+
+	switch settings.Source {
+	case "instagram":
+		newCrawler = crawler.NewInstagramCrawler
+	default:
+		if len(crawlerConfig.Seeds) == 0 {
+			crawlerConfig.Seeds = []crawler.Profile{{ID: "1"}}
+		}
 	}
 
-	dummyCrawler, err := crawler.NewDummyCrawler(config, limiterConfig)
+	profilesCrawler, err := newCrawler(crawlerConfig, settings.Limiter)
 	panicOnError(err)
 
-	dummyCrawler.Run()
+	return profilesCrawler.Run(ctx)
 }
 
 func panicOnError(err error) {
@@ -0,0 +1,795 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"nsfw/internal/crawler"
+	"nsfw/internal/output"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of a crawler run.
+// Values are resolved from defaults, then a YAML/JSON file, then env vars, then command line flags.
+type Config struct {
+	Source          string
+	SessionID       crawler.Secret
+	SessionIDFile   string
+	SessionIDSecret string
+	Outputs         []Output
+	WriteBuffer     int
+	FlushInterval   time.Duration
+	CSV             CSV
+	Postgres        Postgres
+	Seeds           []crawler.Profile
+	SeedsFile       string
+	MaxDepth        int
+	Strategy        crawler.Strategy
+	MaxConcurrency  int
+	Limiter         crawler.LimiterConfig
+	Retry           crawler.RetryPolicy
+	Checkpoint      string
+	Resume          bool
+}
+
+// Output is one of the sinks profiles are written to
+// @param Path: PostgreSQL DSN, or path to the output file whose format is picked by its extension
+// @param OnError: reaction to the write errors of this output
+type Output struct {
+	Path    string
+	OnError output.ErrorPolicy
+}
+
+// IsPostgres reports whether the output is a PostgreSQL DSN rather than a file
+func (o Output) IsPostgres() bool {
+	return strings.HasPrefix(o.Path, "postgres://") || strings.HasPrefix(o.Path, "postgresql://")
+}
+
+// CSV holds settings of CSV outputs
+// @param Delimiter: field delimiter
+// @param GalleryFile: path to a separate CSV of gallery URLs keyed by profile ID, gallery URLs are inlined if empty
+type CSV struct {
+	Delimiter   rune
+	GalleryFile string
+}
+
+// Postgres holds settings of PostgreSQL outputs, whose DSN is the output
+// @param BatchSize: profiles upserted at once
+// @param FlushInterval: maximum time a profile stays buffered
+// @param MaxConns: connections of the pool
+type Postgres struct {
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxConns      int
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() Config {
+	return Config{
+		Source:        "dummy",
+		Outputs:       []Output{{Path: "results.csv", OnError: output.FailFast}},
+		WriteBuffer:   256,
+		FlushInterval: 10 * time.Second,
+		CSV:           CSV{Delimiter: ','},
+		Postgres: Postgres{
+			BatchSize:     100,
+			FlushInterval: 5 * time.Second,
+			MaxConns:      4,
+		},
+		Strategy: crawler.BreadthFirst,
+		Limiter: crawler.LimiterConfig{
+			DeferTime:  time.Second,
+			MaxTakes:   10,
+			MaxWorkers: 1,
+		},
+	}
+}
+
+// Load resolves the configuration from command line `args` and env vars looked up with `getenv`.
+// The file is read from the `-config` flag, or the `CONFIG` env var.
+func Load(name string, args []string, getenv func(string) string) (Config, error) {
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := flagSet.String("config", getenv("CONFIG"), "path to a YAML or JSON configuration file (env CONFIG)")
+
+	for _, f := range fields {
+		usage := fmt.Sprintf("%s (env %s)", f.usage, f.env())
+
+		if f.boolean {
+			flagSet.Bool(f.flag, false, usage)
+		} else {
+			flagSet.String(f.flag, "", usage)
+		}
+	}
+
+	if err := flagSet.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	config := Default()
+
+	if *configPath != "" {
+		if err := config.loadFile(*configPath); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, f := range fields {
+		if value := getenv(f.env()); value != "" {
+			if err := f.set(&config, value); err != nil {
+				return Config{}, fmt.Errorf("%s (env %s): %w", f.key, f.env(), err)
+			}
+		}
+	}
+
+	var flagErr error
+
+	flagSet.Visit(func(fl *flag.Flag) {
+		f, ok := fieldsByFlag[fl.Name]
+
+		if !ok || flagErr != nil {
+			return
+		}
+
+		if err := f.set(&config, fl.Value.String()); err != nil {
+			flagErr = fmt.Errorf("%s (flag -%s): %w", f.key, f.flag, err)
+		}
+	})
+
+	if flagErr != nil {
+		return Config{}, flagErr
+	}
+
+	return config, config.Validate()
+}
+
+// Validate checks all values, the returned error names the first invalid key
+func (c Config) Validate() error {
+	switch c.Source {
+	case "dummy", "instagram":
+	default:
+		return fmt.Errorf("source: unknown source %q, expected dummy or instagram", c.Source)
+	}
+
+	if c.Source == "instagram" && len(c.credentials()) == 0 {
+		return errors.New("session_id: required for the instagram source, or session_id_file or session_id_secret")
+	}
+
+	if len(c.Outputs) == 0 {
+		return errors.New("output: must not be empty")
+	}
+
+	for idx, out := range c.Outputs {
+		if out.Path == "" {
+			return fmt.Errorf("output[%d]: must have a path", idx)
+		}
+
+		if _, err := output.ParseErrorPolicy(string(out.OnError)); out.OnError != "" && err != nil {
+			return fmt.Errorf("output[%d]: %w", idx, err)
+		}
+	}
+
+	if c.WriteBuffer < 0 {
+		return errors.New("write_buffer: must not be negative")
+	}
+
+	if c.FlushInterval < 0 {
+		return errors.New("flush_interval: must not be negative")
+	}
+
+	switch c.CSV.Delimiter {
+	case 0, '"', '\r', '\n', utf8.RuneError:
+		return errors.New("csv.delimiter: must be a single character other than a quote or a line break")
+	}
+
+	if c.Postgres.BatchSize <= 0 {
+		return errors.New("postgres.batch_size: must be positive")
+	}
+
+	if c.Postgres.FlushInterval <= 0 {
+		return errors.New("postgres.flush_interval: must be positive")
+	}
+
+	if c.Postgres.MaxConns <= 0 {
+		return errors.New("postgres.max_conns: must be positive")
+	}
+
+	if c.MaxDepth < 0 {
+		return errors.New("max_depth: must not be negative")
+	}
+
+	if c.MaxConcurrency < 0 {
+		return errors.New("max_concurrency: must not be negative")
+	}
+
+	if c.Limiter.DeferTime <= 0 {
+		return errors.New("limiter.defer_time: must be positive")
+	}
+
+	if c.Limiter.MaxTakes <= 0 {
+		return errors.New("limiter.max_takes: must be positive")
+	}
+
+	if c.Limiter.MaxWorkers <= 0 {
+		return errors.New("limiter.max_workers: must be positive")
+	}
+
+	if c.Limiter.Rate < 0 {
+		return errors.New("limiter.rate: must not be negative")
+	}
+
+	if c.Limiter.Burst < 0 {
+		return errors.New("limiter.burst: must not be negative")
+	}
+
+	if c.Limiter.MinRate < 0 {
+		return errors.New("limiter.min_rate: must not be negative")
+	}
+
+	if c.Limiter.MaxRate < 0 {
+		return errors.New("limiter.max_rate: must not be negative")
+	}
+
+	if c.Limiter.MaxRate > 0 && c.Limiter.MinRate > c.Limiter.MaxRate {
+		return errors.New("limiter.min_rate: must not be greater than limiter.max_rate")
+	}
+
+	if c.Retry.MaxAttempts < 0 {
+		return errors.New("retry.max_attempts: must not be negative")
+	}
+
+	if c.Retry.BaseDelay < 0 {
+		return errors.New("retry.base_delay: must not be negative")
+	}
+
+	if c.Retry.MaxDelay < 0 {
+		return errors.New("retry.max_delay: must not be negative")
+	}
+
+	if c.Retry.Jitter < 0 || c.Retry.Jitter > 1 {
+		return errors.New("retry.jitter: must be between 0 and 1")
+	}
+
+	if c.Resume && c.Checkpoint == "" {
+		return errors.New("resume: requires a checkpoint file")
+	}
+
+	// Graphs are whole documents, a resumed run would replace the graph of the previous runs with its own
+	for idx, out := range c.Outputs {
+		if _, ok := output.GraphFormatOf(out.Path); ok && c.Resume {
+			return fmt.Errorf("output[%d]: graph outputs can't be resumed, since they are rewritten by every run", idx)
+		}
+	}
+
+	for idx, seed := range c.Seeds {
+		if seed.ID == "" && seed.Username == "" {
+			return fmt.Errorf("seeds[%d]: must have an id or a username", idx)
+		}
+
+		if c.Source == "instagram" && seed.Username == "" {
+			return fmt.Errorf("seeds[%d]: must have a username for the instagram source, profiles are fetched by username", idx)
+		}
+	}
+
+	return nil
+}
+
+// CrawlerConfig converts into a crawler.Config, loading `SeedsFile` if set.
+// Checkpoint, Client, Visited and Writer are left for the caller to fill in.
+func (c Config) CrawlerConfig() (crawler.Config, error) {
+	seeds := append([]crawler.Profile{}, c.Seeds...)
+
+	if c.SeedsFile != "" {
+		fileSeeds, err := crawler.LoadSeeds(c.SeedsFile)
+
+		if err != nil {
+			return crawler.Config{}, fmt.Errorf("seeds_file: %w", err)
+		}
+
+		for idx, seed := range fileSeeds {
+			if c.Source == "instagram" && seed.Username == "" {
+				return crawler.Config{}, fmt.Errorf("seeds_file: seed #%d must have a username for the instagram source, profiles are fetched by username", idx)
+			}
+		}
+
+		seeds = append(seeds, fileSeeds...)
+	}
+
+	crawlerConfig := crawler.Config{
+		MaxConcurrency: c.MaxConcurrency,
+		MaxDepth:       c.MaxDepth,
+		Resume:         c.Resume,
+		Retry:          c.Retry,
+		Seeds:          seeds,
+		Strategy:       c.Strategy,
+		WriteBuffer:    c.WriteBuffer,
+		FlushInterval:  c.FlushInterval,
+	}
+
+	if credentials := c.credentials(); len(credentials) > 0 {
+		crawlerConfig.Credentials = credentials
+	}
+
+	return crawlerConfig, nil
+}
+
+/* Private stuffs */
+
+// credentials chains the configured session ID providers, in order of precedence
+func (c Config) credentials() crawler.ChainCredentials {
+	providers := crawler.ChainCredentials{}
+
+	if c.SessionID != "" {
+		providers = append(providers, crawler.StaticCredentials(c.SessionID))
+	}
+
+	if c.SessionIDFile != "" {
+		providers = append(providers, crawler.FileCredentials{Path: c.SessionIDFile})
+	}
+
+	if c.SessionIDSecret != "" {
+		providers = append(providers, crawler.DockerSecretCredentials(c.SessionIDSecret))
+	}
+
+	return providers
+}
+
+// field describes a scalar setting, addressable by a file key, an env var and a flag.
+// Boolean fields are set by their flag alone, e.g. `-resume` instead of `-resume true`.
+type field struct {
+	key     string
+	flag    string
+	usage   string
+	boolean bool
+	set     func(*Config, string) error
+}
+
+// env derives the env var name from the key, e.g. `limiter.max_takes` becomes `LIMITER_MAX_TAKES`
+func (f field) env() string {
+	return strings.ToUpper(strings.ReplaceAll(f.key, ".", "_"))
+}
+
+var fields = []field{
+	{
+		key:   "source",
+		flag:  "source",
+		usage: "profiles source: dummy or instagram",
+		set:   func(c *Config, value string) error { c.Source = value; return nil },
+	},
+	{
+		key:   "session_id",
+		flag:  "session-id",
+		usage: "instagram cookie session ID, prefer session_id_file or session_id_secret",
+		set:   func(c *Config, value string) error { c.SessionID = crawler.Secret(value); return nil },
+	},
+	{
+		key:   "session_id_file",
+		flag:  "session-id-file",
+		usage: "path to a file containing the instagram cookie session ID",
+		set:   func(c *Config, value string) error { c.SessionIDFile = value; return nil },
+	},
+	{
+		key:   "session_id_secret",
+		flag:  "session-id-secret",
+		usage: "name of a Docker secret containing the instagram cookie session ID",
+		set:   func(c *Config, value string) error { c.SessionIDSecret = value; return nil },
+	},
+	{
+		key:  "output",
+		flag: "output",
+		usage: "comma separated outputs: PostgreSQL DSNs (postgres://...), or paths to SQLite (.db, .sqlite, .sqlite3), JSON Lines (.jsonl, .ndjson), suggestion graph (.graphml, .gexf, .dot, .gv) or CSV files otherwise. " +
+			"Write errors abort the crawl, unless the output is prefixed with best-effort: or retry:, e.g. results.csv,best-effort:graph.gexf",
+		set: func(c *Config, value string) error { c.Outputs = parseOutputs(value); return nil },
+	},
+	{
+		key:   "write_buffer",
+		flag:  "write-buffer",
+		usage: "profiles and edge batches buffered for slow outputs before crawling blocks, unbuffered if 0",
+		set:   func(c *Config, value string) error { return parseInt(value, &c.WriteBuffer) },
+	},
+	{
+		key:   "flush_interval",
+		flag:  "flush-interval",
+		usage: "time between flushes of the outputs, e.g. 10s, only flushed once the crawl is over if 0",
+		set:   func(c *Config, value string) error { return parseDuration(value, &c.FlushInterval) },
+	},
+	{
+		key:   "csv.delimiter",
+		flag:  "csv-delimiter",
+		usage: "field delimiter of CSV outputs, e.g. ; or \\t for tabs",
+		set:   func(c *Config, value string) error { return parseRune(value, &c.CSV.Delimiter) },
+	},
+	{
+		key:   "csv.gallery_file",
+		flag:  "csv-gallery",
+		usage: "path to a separate CSV of gallery URLs keyed by profile ID, gallery URLs are inlined if empty",
+		set:   func(c *Config, value string) error { c.CSV.GalleryFile = value; return nil },
+	},
+	{
+		key:   "postgres.batch_size",
+		flag:  "postgres-batch-size",
+		usage: "profiles upserted at once by PostgreSQL outputs",
+		set:   func(c *Config, value string) error { return parseInt(value, &c.Postgres.BatchSize) },
+	},
+	{
+		key:   "postgres.flush_interval",
+		flag:  "postgres-flush-interval",
+		usage: "maximum time a profile stays buffered by PostgreSQL outputs, e.g. 5s",
+		set:   func(c *Config, value string) error { return parseDuration(value, &c.Postgres.FlushInterval) },
+	},
+	{
+		key:   "postgres.max_conns",
+		flag:  "postgres-max-conns",
+		usage: "connections of the pool of PostgreSQL outputs",
+		set:   func(c *Config, value string) error { return parseInt(value, &c.Postgres.MaxConns) },
+	},
+	{
+		key:   "seeds_file",
+		flag:  "seeds",
+		usage: "path to a seeds file: one username or ID per line (.txt), id,username rows (.csv) or a JSON array (.json)",
+		set:   func(c *Config, value string) error { c.SeedsFile = value; return nil },
+	},
+	{
+		key:   "max_depth",
+		flag:  "max-depth",
+		usage: "maximum hops from the seeds, unlimited if 0",
+		set:   func(c *Config, value string) error { return parseInt(value, &c.MaxDepth) },
+	},
+	{
+		key:   "strategy",
+		flag:  "strategy",
+		usage: "crawl order: bfs or dfs",
+		set: func(c *Config, value string) (err error) {
+			c.Strategy, err = crawler.ParseStrategy(value)
+			return err
+		},
+	},
+	{
+		key:   "checkpoint",
+		flag:  "checkpoint",
+		usage: "path to a journal of the crawl progress, the crawl isn't resumable if empty",
+		set:   func(c *Config, value string) error { c.Checkpoint = value; return nil },
+	},
+	{
+		key:     "resume",
+		flag:    "resume",
+		usage:   "resume the crawl recorded in the checkpoint instead of starting from the seeds",
+		boolean: true,
+		set:     func(c *Config, value string) error { return parseBool(value, &c.Resume) },
+	},
+	{
+		key:   "max_concurrency",
+		flag:  "max-concurrency",
+		usage: "maximum profiles crawled at once, whatever the limiter allows, unlimited if 0",
+		set:   func(c *Config, value string) error { return parseInt(value, &c.MaxConcurrency) },
+	},
+	{
+		key:   "limiter.defer_time",
+		flag:  "defer-time",
+		usage: "time between limiter ticks, e.g. 500ms, unused if limiter.rate is set",
+		set:   func(c *Config, value string) error { return parseDuration(value, &c.Limiter.DeferTime) },
+	},
+	{
+		key:   "limiter.max_takes",
+		flag:  "max-takes",
+		usage: "maximum amount of profiles to crawl",
+		set:   func(c *Config, value string) error { return parseInt(value, &c.Limiter.MaxTakes) },
+	},
+	{
+		key:   "limiter.max_workers",
+		flag:  "max-workers",
+		usage: "amount of profiles allowed per limiter tick, unused if limiter.rate is set, see max_concurrency to bound profiles crawled at once",
+		set:   func(c *Config, value string) error { return parseInt(value, &c.Limiter.MaxWorkers) },
+	},
+	{
+		key:   "limiter.rate",
+		flag:  "rate",
+		usage: "sustained profiles per second, e.g. 0.5, switches to a token bucket limiter instead of ticks if positive",
+		set:   func(c *Config, value string) error { return parseFloat(value, &c.Limiter.Rate) },
+	},
+	{
+		key:   "limiter.burst",
+		flag:  "burst",
+		usage: "profiles allowed at once by the token bucket limiter after an idle period, 1 if 0",
+		set:   func(c *Config, value string) error { return parseInt(value, &c.Limiter.Burst) },
+	},
+	{
+		key:   "limiter.min_rate",
+		flag:  "min-rate",
+		usage: "floor of the adaptive limiter in profiles per second, a tenth of limiter.max_rate if 0",
+		set:   func(c *Config, value string) error { return parseFloat(value, &c.Limiter.MinRate) },
+	},
+	{
+		key:   "limiter.max_rate",
+		flag:  "max-rate",
+		usage: "ceiling of the adaptive limiter in profiles per second, switches to a rate adapted to server responses if positive, starting at limiter.rate",
+		set:   func(c *Config, value string) error { return parseFloat(value, &c.Limiter.MaxRate) },
+	},
+	{
+		key:   "retry.max_attempts",
+		flag:  "retry-max-attempts",
+		usage: "total attempts per request, no retries if 0 or 1",
+		set:   func(c *Config, value string) error { return parseInt(value, &c.Retry.MaxAttempts) },
+	},
+	{
+		key:   "retry.base_delay",
+		flag:  "retry-base-delay",
+		usage: "delay before the first retry, doubled on every following retry",
+		set:   func(c *Config, value string) error { return parseDuration(value, &c.Retry.BaseDelay) },
+	},
+	{
+		key:   "retry.max_delay",
+		flag:  "retry-max-delay",
+		usage: "upper bound of a single retry delay, including Retry-After values",
+		set:   func(c *Config, value string) error { return parseDuration(value, &c.Retry.MaxDelay) },
+	},
+	{
+		key:   "retry.jitter",
+		flag:  "retry-jitter",
+		usage: "ratio between 0 and 1 of every retry delay which is randomly shaved off",
+		set:   func(c *Config, value string) error { return parseFloat(value, &c.Retry.Jitter) },
+	},
+}
+
+var fieldsByKey, fieldsByFlag = indexFields()
+
+func indexFields() (map[string]field, map[string]field) {
+	byKey := map[string]field{}
+	byFlag := map[string]field{}
+
+	for _, f := range fields {
+		byKey[f.key] = f
+		byFlag[f.flag] = f
+	}
+
+	return byKey, byFlag
+}
+
+// loadFile reads a YAML file, JSON files are parsed as well since JSON is a subset of YAML
+func (c *Config) loadFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	document := map[string]interface{}{}
+
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if rawOutputs, ok := document["output"].([]interface{}); ok {
+		delete(document, "output")
+
+		if err := c.setOutputs(rawOutputs); err != nil {
+			return fmt.Errorf("%s: output: %w", path, err)
+		}
+	}
+
+	if rawSeeds, ok := document["seeds"]; ok {
+		delete(document, "seeds")
+
+		if err := c.setSeeds(rawSeeds); err != nil {
+			return fmt.Errorf("%s: seeds: %w", path, err)
+		}
+	}
+
+	values := map[string]string{}
+
+	if err := flatten("", document, values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	// Apply keys in a stable order, so that the first error is deterministic
+	keys := []string{}
+
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		f, ok := fieldsByKey[key]
+
+		if !ok {
+			return fmt.Errorf("%s: %s: unknown key", path, key)
+		}
+
+		if err := f.set(c, values[key]); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+	}
+
+	return nil
+}
+
+// setSeeds accepts a list of usernames/IDs, or of `{id, username}` objects
+func (c *Config) setSeeds(rawSeeds interface{}) error {
+	list, ok := rawSeeds.([]interface{})
+
+	if !ok {
+		return errors.New("expected a list")
+	}
+
+	seeds := []crawler.Profile{}
+
+	for idx, rawSeed := range list {
+		switch seed := rawSeed.(type) {
+		case string, int:
+			parsedSeeds, _ := crawler.ReadSeeds(strings.NewReader(fmt.Sprint(seed)), crawler.SeedsFormatLines)
+			seeds = append(seeds, parsedSeeds...)
+		case map[string]interface{}:
+			profile := crawler.Profile{}
+
+			for key, value := range seed {
+				switch key {
+				case "id":
+					profile.ID = fmt.Sprint(value)
+				case "username":
+					profile.Username = fmt.Sprint(value)
+				default:
+					return fmt.Errorf("[%d].%s: unknown key", idx, key)
+				}
+			}
+
+			seeds = append(seeds, profile)
+		default:
+			return fmt.Errorf("[%d]: expected a username, an ID or an object", idx)
+		}
+	}
+
+	c.Seeds = seeds
+	return nil
+}
+
+// setOutputs accepts a list of outputs, or of `{path, on_error}` objects
+func (c *Config) setOutputs(list []interface{}) error {
+	outputs := []Output{}
+
+	for idx, rawOutput := range list {
+		switch typedOutput := rawOutput.(type) {
+		case string:
+			outputs = append(outputs, parseOutput(typedOutput))
+		case map[string]interface{}:
+			out := Output{OnError: output.FailFast}
+
+			for key, value := range typedOutput {
+				switch key {
+				case "path":
+					out.Path = fmt.Sprint(value)
+				case "on_error":
+					policy, err := output.ParseErrorPolicy(fmt.Sprint(value))
+
+					if err != nil {
+						return fmt.Errorf("[%d].%s: %w", idx, key, err)
+					}
+
+					out.OnError = policy
+				default:
+					return fmt.Errorf("[%d].%s: unknown key", idx, key)
+				}
+			}
+
+			outputs = append(outputs, out)
+		default:
+			return fmt.Errorf("[%d]: expected a path or an object", idx)
+		}
+	}
+
+	c.Outputs = outputs
+	return nil
+}
+
+// parseOutputs splits comma separated outputs, see parseOutput
+func parseOutputs(value string) []Output {
+	outputs := []Output{}
+
+	for _, rawOutput := range strings.Split(value, ",") {
+		if rawOutput = strings.TrimSpace(rawOutput); rawOutput != "" {
+			outputs = append(outputs, parseOutput(rawOutput))
+		}
+	}
+
+	return outputs
+}
+
+// parseOutput reads an output path, optionally prefixed with its error policy, e.g. `retry:postgres://...`
+func parseOutput(value string) Output {
+	if idx := strings.Index(value, ":"); idx > 0 {
+		if policy, err := output.ParseErrorPolicy(value[:idx]); err == nil {
+			return Output{Path: value[idx+1:], OnError: policy}
+		}
+	}
+
+	return Output{Path: value, OnError: output.FailFast}
+}
+
+// flatten turns nested maps into dotted keys, e.g. `limiter: {max_takes: 1}` into `limiter.max_takes`
+func flatten(prefix string, document map[string]interface{}, values map[string]string) error {
+	for key, value := range document {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch typedValue := value.(type) {
+		case map[string]interface{}:
+			if err := flatten(key, typedValue, values); err != nil {
+				return err
+			}
+		case []interface{}:
+			return fmt.Errorf("%s: expected a scalar value", key)
+		case nil:
+			continue
+		default:
+			values[key] = fmt.Sprint(typedValue)
+		}
+	}
+
+	return nil
+}
+
+func parseInt(value string, target *int) error {
+	number, err := strconv.Atoi(value)
+
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", value)
+	}
+
+	*target = number
+	return nil
+}
+
+func parseBool(value string, target *bool) error {
+	boolean, err := strconv.ParseBool(value)
+
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", value)
+	}
+
+	*target = boolean
+	return nil
+}
+
+// parseRune accepts a single character, or `\t` for tabs
+func parseRune(value string, target *rune) error {
+	if value == "\\t" {
+		value = "\t"
+	}
+
+	if utf8.RuneCountInString(value) != 1 {
+		return fmt.Errorf("invalid character %q", value)
+	}
+
+	*target, _ = utf8.DecodeRuneInString(value)
+	return nil
+}
+
+func parseFloat(value string, target *float64) error {
+	number, err := strconv.ParseFloat(value, 64)
+
+	if err != nil {
+		return fmt.Errorf("invalid number %q", value)
+	}
+
+	*target = number
+	return nil
+}
+
+func parseDuration(value string, target *time.Duration) error {
+	duration, err := time.ParseDuration(value)
+
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", value)
+	}
+
+	*target = duration
+	return nil
+}
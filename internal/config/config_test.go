@@ -0,0 +1,420 @@
+package config
+
+import (
+	"fmt"
+	"nsfw/internal/crawler"
+	"nsfw/internal/output"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadDefault(t *testing.T) {
+	config, err := Load("test", nil, fakeEnv(nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, Default(), config)
+}
+
+func TestLoadYAMLFile(t *testing.T) {
+	path := writeFile(t, "crawler.yaml", `
+source: instagram
+session_id: fake-session
+output: out.csv
+write_buffer: 10
+flush_interval: 1m
+csv:
+  delimiter: ";"
+  gallery_file: gallery.csv
+postgres:
+  batch_size: 50
+  flush_interval: 1s
+  max_conns: 8
+max_depth: 2
+strategy: dfs
+max_concurrency: 4
+seeds:
+  - "@user_1234"
+  - user_2345
+  - id: "3456"
+    username: user_3456
+limiter:
+  defer_time: 500ms
+  max_takes: 100
+  max_workers: 2
+  rate: 1.5
+  burst: 3
+  min_rate: 0.5
+  max_rate: 4
+retry:
+  max_attempts: 3
+  base_delay: 1s
+  max_delay: 1m
+  jitter: 0.2
+`)
+
+	config, err := Load("test", []string{"-config", path}, fakeEnv(nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, Config{
+		Source:        "instagram",
+		SessionID:     "fake-session",
+		Outputs:       []Output{{Path: "out.csv", OnError: output.FailFast}},
+		WriteBuffer:   10,
+		FlushInterval: time.Minute,
+		CSV: CSV{
+			Delimiter:   ';',
+			GalleryFile: "gallery.csv",
+		},
+		Postgres: Postgres{
+			BatchSize:     50,
+			FlushInterval: time.Second,
+			MaxConns:      8,
+		},
+		Seeds: []crawler.Profile{
+			{Username: "user_1234"},
+			{Username: "user_2345"},
+			{ID: "3456", Username: "user_3456"},
+		},
+		MaxDepth:       2,
+		Strategy:       crawler.DepthFirst,
+		MaxConcurrency: 4,
+		Limiter: crawler.LimiterConfig{
+			DeferTime:  500 * time.Millisecond,
+			MaxTakes:   100,
+			MaxWorkers: 2,
+			Rate:       1.5,
+			Burst:      3,
+			MinRate:    0.5,
+			MaxRate:    4,
+		},
+		Retry: crawler.RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Second,
+			MaxDelay:    time.Minute,
+			Jitter:      0.2,
+		},
+	}, config)
+}
+
+func TestLoadJSONFile(t *testing.T) {
+	path := writeFile(t, "crawler.json", `{"output": "out.csv", "limiter": {"max_takes": 5}}`)
+
+	config, err := Load("test", nil, fakeEnv(map[string]string{"CONFIG": path}))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Output{{Path: "out.csv", OnError: output.FailFast}}, config.Outputs)
+	assert.Equal(t, 5, config.Limiter.MaxTakes)
+	assert.Equal(t, time.Second, config.Limiter.DeferTime)
+}
+
+func TestLoadOverrides(t *testing.T) {
+	path := writeFile(t, "crawler.yaml", "output: file.csv\nlimiter:\n  max_takes: 1\n")
+	env := fakeEnv(map[string]string{
+		"OUTPUT":            "env.csv",
+		"LIMITER_MAX_TAKES": "2",
+		"STRATEGY":          "dfs",
+	})
+
+	config, err := Load("test", []string{"-config", path, "-max-takes", "3", "-defer-time", "2s"}, env)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Output{{Path: "env.csv", OnError: output.FailFast}}, config.Outputs)
+	assert.Equal(t, crawler.DepthFirst, config.Strategy)
+	assert.Equal(t, 3, config.Limiter.MaxTakes)
+	assert.Equal(t, 2*time.Second, config.Limiter.DeferTime)
+
+	config, err = Load("test", []string{"-csv-delimiter", "\\t"}, fakeEnv(nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, '\t', config.CSV.Delimiter)
+}
+
+func TestLoadResume(t *testing.T) {
+	config, err := Load("test", []string{"-checkpoint", "crawl.jsonl", "--resume"}, fakeEnv(nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "crawl.jsonl", config.Checkpoint)
+	assert.Equal(t, true, config.Resume)
+
+	config, err = Load("test", nil, fakeEnv(map[string]string{"CHECKPOINT": "crawl.jsonl", "RESUME": "true"}))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, config.Resume)
+
+	crawlerConfig, _ := config.CrawlerConfig()
+	assert.Equal(t, true, crawlerConfig.Resume)
+}
+
+func TestLoadErrors(t *testing.T) {
+	testCases := []struct {
+		file     string
+		args     []string
+		env      map[string]string
+		expected string
+	}{
+		{
+			file:     "limiter:\n  max_takes: abc\n",
+			expected: `limiter.max_takes: invalid integer "abc"`,
+		},
+		{
+			file:     "limiter:\n  max_worker: 1\n",
+			expected: "limiter.max_worker: unknown key",
+		},
+		{
+			file:     "seeds: 1234\n",
+			expected: "seeds: expected a list",
+		},
+		{
+			file:     "seeds:\n  - id: 1234\n    name: user\n",
+			expected: "seeds: [0].name: unknown key",
+		},
+		{
+			env:      map[string]string{"LIMITER_DEFER_TIME": "soon"},
+			expected: `limiter.defer_time (env LIMITER_DEFER_TIME): invalid duration "soon"`,
+		},
+		{
+			args:     []string{"-strategy", "random"},
+			expected: `strategy (flag -strategy): unknown strategy "random", expected bfs or dfs`,
+		},
+		{
+			args:     []string{"-source", "twitter"},
+			expected: `source: unknown source "twitter", expected dummy or instagram`,
+		},
+		{
+			args:     []string{"-source", "instagram"},
+			expected: "session_id: required for the instagram source",
+		},
+		{
+			args:     []string{"-max-workers", "0"},
+			expected: "limiter.max_workers: must be positive",
+		},
+		{
+			args:     []string{"-retry-jitter", "high"},
+			expected: `retry.jitter (flag -retry-jitter): invalid number "high"`,
+		},
+		{
+			args:     []string{"-csv-delimiter", ",,"},
+			expected: `csv.delimiter (flag -csv-delimiter): invalid character ",,"`,
+		},
+		{
+			env:      map[string]string{"CSV_DELIMITER": "\""},
+			expected: "csv.delimiter: must be a single character other than a quote or a line break",
+		},
+		{
+			env:      map[string]string{"RESUME": "maybe"},
+			expected: `resume (env RESUME): invalid boolean "maybe"`,
+		},
+		{
+			args:     []string{"-resume"},
+			expected: "resume: requires a checkpoint file",
+		},
+		{
+			args:     []string{"-checkpoint", "crawl.jsonl", "-resume", "-output", "graph.gexf"},
+			expected: "output[0]: graph outputs can't be resumed, since they are rewritten by every run",
+		},
+		{
+			env:      map[string]string{"RETRY_JITTER": "1.5"},
+			expected: "retry.jitter: must be between 0 and 1",
+		},
+	}
+
+	for _, testCase := range testCases {
+		args := testCase.args
+
+		if testCase.file != "" {
+			args = append([]string{"-config", writeFile(t, "crawler.yaml", testCase.file)}, args...)
+		}
+
+		_, err := Load("test", args, fakeEnv(testCase.env))
+		assert.Error(t, err)
+
+		if err != nil {
+			assert.Contains(t, err.Error(), testCase.expected)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	config := Default()
+	assert.Equal(t, nil, config.Validate())
+
+	config.Outputs = nil
+	assert.EqualError(t, config.Validate(), "output: must not be empty")
+
+	config.Outputs = []Output{{Path: "out.csv", OnError: "ignore"}}
+	assert.EqualError(t, config.Validate(), `output[0]: unknown error policy "ignore", expected fail-fast, best-effort or retry`)
+
+	config = Default()
+	config.WriteBuffer = -1
+	assert.EqualError(t, config.Validate(), "write_buffer: must not be negative")
+
+	config = Default()
+	config.FlushInterval = -time.Second
+	assert.EqualError(t, config.Validate(), "flush_interval: must not be negative")
+
+	config = Default()
+	config.Limiter.Rate = -1
+	assert.EqualError(t, config.Validate(), "limiter.rate: must not be negative")
+
+	config = Default()
+	config.Limiter.Burst = -1
+	assert.EqualError(t, config.Validate(), "limiter.burst: must not be negative")
+
+	config = Default()
+	config.Limiter.MinRate = -1
+	assert.EqualError(t, config.Validate(), "limiter.min_rate: must not be negative")
+
+	config = Default()
+	config.Limiter.MaxRate = -1
+	assert.EqualError(t, config.Validate(), "limiter.max_rate: must not be negative")
+
+	config = Default()
+	config.Limiter.MinRate = 5
+	config.Limiter.MaxRate = 2
+	assert.EqualError(t, config.Validate(), "limiter.min_rate: must not be greater than limiter.max_rate")
+
+	config = Default()
+	config.MaxDepth = -1
+	assert.EqualError(t, config.Validate(), "max_depth: must not be negative")
+
+	config = Default()
+	config.MaxConcurrency = -1
+	assert.EqualError(t, config.Validate(), "max_concurrency: must not be negative")
+
+	config = Default()
+	config.Limiter.DeferTime = 0
+	assert.EqualError(t, config.Validate(), "limiter.defer_time: must be positive")
+
+	config = Default()
+	config.Limiter.MaxTakes = 0
+	assert.EqualError(t, config.Validate(), "limiter.max_takes: must be positive")
+
+	config = Default()
+	config.Retry.MaxAttempts = -1
+	assert.EqualError(t, config.Validate(), "retry.max_attempts: must not be negative")
+
+	config = Default()
+	config.Retry.BaseDelay = -time.Second
+	assert.EqualError(t, config.Validate(), "retry.base_delay: must not be negative")
+
+	config = Default()
+	config.Retry.MaxDelay = -time.Second
+	assert.EqualError(t, config.Validate(), "retry.max_delay: must not be negative")
+
+	config = Default()
+	config.Postgres.BatchSize = 0
+	assert.EqualError(t, config.Validate(), "postgres.batch_size: must be positive")
+
+	config = Default()
+	config.Seeds = []crawler.Profile{{ID: "1"}, {}}
+	assert.EqualError(t, config.Validate(), "seeds[1]: must have an id or a username")
+
+	config = Default()
+	config.Source = "instagram"
+	config.SessionID = "fake-session"
+	config.Seeds = []crawler.Profile{{Username: "user_1"}, {ID: "2"}}
+	assert.EqualError(t, config.Validate(), "seeds[1]: must have a username for the instagram source, profiles are fetched by username")
+}
+
+func TestCredentials(t *testing.T) {
+	config := Default()
+
+	crawlerConfig, _ := config.CrawlerConfig()
+	assert.Equal(t, nil, crawlerConfig.Credentials)
+
+	sessionIDFile := writeFile(t, "session_id", "file-session")
+	config, err := Load("test", []string{"-source", "instagram", "-session-id-file", sessionIDFile}, fakeEnv(nil))
+	assert.Equal(t, nil, err)
+
+	crawlerConfig, _ = config.CrawlerConfig()
+	sessionID, err := crawlerConfig.Credentials.SessionID()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "file-session", sessionID.Reveal())
+
+	// An inline session ID takes precedence, but never shows up in outputs
+	config.SessionID = "inline-session"
+	assert.NotContains(t, fmt.Sprintf("%+v", config), "inline-session")
+
+	crawlerConfig, _ = config.CrawlerConfig()
+	sessionID, _ = crawlerConfig.Credentials.SessionID()
+	assert.Equal(t, "inline-session", sessionID.Reveal())
+}
+
+func TestLoadOutputs(t *testing.T) {
+	config, err := Load("test", []string{"-output", "out.csv, best-effort:graph.gexf,retry:postgres://localhost/nsfw"}, fakeEnv(nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Output{
+		{Path: "out.csv", OnError: output.FailFast},
+		{Path: "graph.gexf", OnError: output.BestEffort},
+		{Path: "postgres://localhost/nsfw", OnError: output.Retry},
+	}, config.Outputs)
+
+	path := writeFile(t, "crawler.yaml", `
+output:
+  - out.csv
+  - path: out.jsonl
+    on_error: best-effort
+`)
+
+	config, err = Load("test", []string{"-config", path}, fakeEnv(nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Output{
+		{Path: "out.csv", OnError: output.FailFast},
+		{Path: "out.jsonl", OnError: output.BestEffort},
+	}, config.Outputs)
+
+	path = writeFile(t, "crawler.yaml", "output:\n  - path: out.csv\n    on_error: ignore\n")
+	_, err = Load("test", []string{"-config", path}, fakeEnv(nil))
+	assert.Contains(t, err.Error(), `output: [0].on_error: unknown error policy "ignore"`)
+}
+
+func TestIsPostgres(t *testing.T) {
+	assert.False(t, Default().Outputs[0].IsPostgres())
+
+	config, err := Load("test", []string{"-output", "postgres://localhost/nsfw", "-postgres-batch-size", "10"}, fakeEnv(nil))
+	assert.Equal(t, nil, err)
+	assert.True(t, config.Outputs[0].IsPostgres())
+	assert.Equal(t, 10, config.Postgres.BatchSize)
+}
+
+func TestCrawlerConfig(t *testing.T) {
+	config := Default()
+	config.Seeds = []crawler.Profile{{ID: "1"}}
+	config.SeedsFile = writeFile(t, "seeds.txt", "2\nuser_3\n")
+	config.MaxDepth = 3
+	config.MaxConcurrency = 4
+
+	crawlerConfig, err := config.CrawlerConfig()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []crawler.Profile{{ID: "1"}, {ID: "2"}, {Username: "user_3"}}, crawlerConfig.Seeds)
+	assert.Equal(t, 3, crawlerConfig.MaxDepth)
+	assert.Equal(t, 4, crawlerConfig.MaxConcurrency)
+	assert.Equal(t, 256, crawlerConfig.WriteBuffer)
+	assert.Equal(t, 10*time.Second, crawlerConfig.FlushInterval)
+
+	// Instagram profiles are fetched by username, so ID-only seeds are refused
+	config.Source = "instagram"
+	config.Seeds = []crawler.Profile{{Username: "user_1"}}
+	_, err = config.CrawlerConfig()
+	assert.EqualError(t, err, "seeds_file: seed #0 must have a username for the instagram source, profiles are fetched by username")
+
+	config.Source = "dummy"
+	config.SeedsFile = filepath.Join(t.TempDir(), "missing.txt")
+	_, err = config.CrawlerConfig()
+	assert.Contains(t, err.Error(), "seeds_file: ")
+}
+
+/* Private stuffs */
+
+func fakeEnv(values map[string]string) func(string) string {
+	return func(key string) string {
+		return values[key]
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
@@ -0,0 +1,292 @@
+package crawler
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Feedback describes a response received by a source, so that adaptive limiters can pace the following takes
+// @param StatusCode: the HTTP status code of the response, `0` if the request failed before any response
+// @param Latency: time between sending the request and receiving the response
+// @param Header: the response headers, read for `Retry-After` and rate limit headers
+// @param Err: the transport error of a request without any response
+type Feedback struct {
+	StatusCode int
+	Latency    time.Duration
+	Header     http.Header
+	Err        error
+}
+
+// FeedbackReceiver is implemented by limiters adapting their rate to the responses of the source
+type FeedbackReceiver interface {
+	Feedback(Feedback)
+}
+
+// FeedbackReporter is implemented by sources able to report every response they receive, retries included.
+// `receive` is registered once before the first run, and called concurrently by crawl jobs.
+type FeedbackReporter interface {
+	OnFeedback(receive func(Feedback))
+}
+
+// Feedback adapts the rate to a response of the source, following AIMD:
+// - the rate is halved on HTTP 429, 5xx, transport errors or when recent latencies are twice the usual ones
+// - it is increased otherwise, by `(MaxRate - MinRate) / adaptiveSteps` per second of healthy responses
+// - takes are paused until `Retry-After`, or until the rate limit window resets once no request remains
+// - the rate is capped by the requests remaining in the rate limit window
+func (a *adaptiveLimiter) Feedback(feedback Feedback) {
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if retryAfter := ParseRetryAfter(feedback.Header, now); retryAfter > 0 {
+		a.pause(now, retryAfter)
+	}
+
+	rateLimit, limited := ParseRateLimit(feedback.Header, now)
+
+	if limited && rateLimit.Remaining == 0 {
+		a.pause(now, rateLimit.Reset)
+	}
+
+	switch {
+	case feedback.Err != nil, feedback.StatusCode == http.StatusTooManyRequests, feedback.StatusCode >= 500:
+		a.decrease(now, feedback.Latency, "server pushed back")
+
+	case a.observeLatency(feedback.Latency):
+		a.decrease(now, feedback.Latency, "latency rising")
+
+	default:
+		// About `rate` responses are received every second, which add up to a whole step
+		a.setRate(a.rate + (a.maxRate-a.minRate)/adaptiveSteps/a.rate)
+	}
+
+	if limited && rateLimit.Remaining > 0 && rateLimit.Reset > 0 {
+		a.setRate(math.Min(a.rate, float64(rateLimit.Remaining)/rateLimit.Reset.Seconds()))
+	}
+}
+
+// Commit increases the profiles counter by one reserved slot
+func (a *adaptiveLimiter) Commit() {
+	a.budget.settle(true)
+}
+
+// Release gives a reserved slot back
+func (a *adaptiveLimiter) Release() {
+	a.budget.settle(false)
+}
+
+// Take reserves a slot, then blocks until `1 / rate` elapsed since the previous take and any pause is over,
+// or returns `false` if max profiles exceeded, the limiter is closed or `ctx` is done.
+func (a *adaptiveLimiter) Take(ctx context.Context) bool {
+	a.mu.Lock()
+	stop := a.stop
+	a.mu.Unlock()
+
+	if !a.budget.reserve(ctx, stop) {
+		return false
+	}
+
+	if a.takeTurn(ctx, stop) {
+		return true
+	}
+
+	a.budget.settle(false)
+	return false
+}
+
+// Close refuses all following takes, and releases the ones waiting for their turn
+func (a *adaptiveLimiter) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	select {
+	case <-a.stop:
+	default:
+		close(a.stop)
+	}
+}
+
+// Reset closes the limiter, then reopens it with a zero counter.
+// The learnt rate and latency are kept, since the next run crawls the same source.
+func (a *adaptiveLimiter) Reset() {
+	a.Close()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.budget.reset()
+	a.stop = make(chan struct{})
+}
+
+/* Private stuffs */
+
+const (
+	// adaptiveSteps: seconds of healthy responses needed to increase the rate from the floor to the ceiling
+	adaptiveSteps = 10
+	// adaptiveDecrease: factor applied to the rate when the server pushes back
+	adaptiveDecrease = 0.5
+	// adaptiveLatencyFactor: recent latencies above this multiple of the usual ones are considered congestion
+	adaptiveLatencyFactor = 2
+	// adaptiveUsualWeight: weight of a new latency in the slow moving average of usual latencies
+	adaptiveUsualWeight = 0.05
+	// adaptiveRecentWeight: weight of a new latency in the fast moving average of recent latencies,
+	// a single slow response isn't enough to double it
+	adaptiveRecentWeight = 0.3
+)
+
+// newAdaptiveLimiter creates an adaptive limiter, see adaptiveLimiter
+func newAdaptiveLimiter(config LimiterConfig) *adaptiveLimiter {
+	minRate := config.MinRate
+
+	if minRate <= 0 {
+		minRate = config.MaxRate / 10
+	}
+
+	maxRate := math.Max(config.MaxRate, minRate)
+
+	// Starts slow unless told otherwise, healthy responses quickly increase the rate
+	rate := config.Rate
+
+	if rate <= 0 {
+		rate = minRate
+	}
+
+	return &adaptiveLimiter{
+		minRate: minRate,
+		maxRate: maxRate,
+		rate:    math.Min(math.Max(rate, minRate), maxRate),
+		budget:  newBudget(config.MaxTakes),
+		changed: make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+}
+
+var (
+	_ Limiter          = (*adaptiveLimiter)(nil)
+	_ FeedbackReceiver = (*adaptiveLimiter)(nil)
+)
+
+// adaptiveLimiter is a Limiter spacing takes by `1 / rate`, where the rate is adapted to the responses
+// of the source within [`minRate`, `maxRate`], see Feedback
+type adaptiveLimiter struct {
+	// Received configurations
+	minRate float64
+	maxRate float64
+
+	// budget: slots of max takes reserved and committed by crawled profiles
+	// mu: guards all following fields
+	// rate: current takes per second
+	// latency: slow moving average of the latency of responses, `0` until the first one
+	// recentLatency: fast moving average of the latency of responses, compared to `latency`
+	// lastTake: time of the previous take, the next one is allowed `1 / rate` later
+	// pausedUntil: no take is allowed before, set by `Retry-After` and exhausted rate limits
+	// decreasedAt: time of the last decrease, responses to takes sent before it don't decrease the rate again
+	// changed: closed and replaced every time the next take may happen sooner, to wake up waiting takes
+	// stop: closed by Close, releases takes waiting for their turn, replaced by Reset
+	budget        *budget
+	mu            sync.Mutex
+	rate          float64
+	latency       time.Duration
+	recentLatency time.Duration
+	lastTake      time.Time
+	pausedUntil   time.Time
+	decreasedAt   time.Time
+	changed       chan struct{}
+	stop          chan struct{}
+}
+
+// takeTurn blocks until the next take is allowed, or returns `false` if `stop` is closed or `ctx` is done
+func (a *adaptiveLimiter) takeTurn(ctx context.Context, stop chan struct{}) bool {
+	for {
+		a.mu.Lock()
+
+		now := time.Now()
+		next := a.lastTake.Add(time.Duration(float64(time.Second) / a.rate))
+
+		if a.pausedUntil.After(next) {
+			next = a.pausedUntil
+		}
+
+		if !now.Before(next) {
+			a.lastTake = now
+			a.mu.Unlock()
+			return true
+		}
+
+		changed := a.changed
+		a.mu.Unlock()
+
+		// Other takes wait for the same turn, only the first one to wake up gets it
+		timer := time.NewTimer(next.Sub(now))
+
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		case <-stop:
+			timer.Stop()
+			return false
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+	}
+}
+
+// decrease multiplies the rate by `adaptiveDecrease`, `mu` must be held.
+// Responses to requests sent before the last decrease are ignored, they don't reflect the decreased rate yet.
+func (a *adaptiveLimiter) decrease(now time.Time, latency time.Duration, reason string) {
+	if now.Add(-latency).Before(a.decreasedAt) {
+		return
+	}
+
+	a.decreasedAt = now
+	a.setRate(a.rate * adaptiveDecrease)
+
+	logrus.WithFields(logrus.Fields{
+		"reason": reason,
+		"rate":   a.rate,
+	}).Warn("limiter backing off")
+}
+
+// pause allows no take for `delay`, `mu` must be held
+func (a *adaptiveLimiter) pause(now time.Time, delay time.Duration) {
+	if until := now.Add(delay); until.After(a.pausedUntil) {
+		a.pausedUntil = until
+
+		logrus.WithField("until", until.Format("15:04:05.000")).Warn("limiter paused by the source")
+	}
+}
+
+// observeLatency updates the moving averages of latencies, and reports whether recent ones are rising.
+// `mu` must be held.
+func (a *adaptiveLimiter) observeLatency(latency time.Duration) bool {
+	if a.latency == 0 {
+		a.latency = latency
+		a.recentLatency = latency
+		return false
+	}
+
+	a.latency += time.Duration(adaptiveUsualWeight * float64(latency-a.latency))
+	a.recentLatency += time.Duration(adaptiveRecentWeight * float64(latency-a.recentLatency))
+
+	return a.recentLatency > adaptiveLatencyFactor*a.latency
+}
+
+// setRate changes the rate within [`minRate`, `maxRate`], waking up waiting takes if it increased, `mu` must be held
+func (a *adaptiveLimiter) setRate(rate float64) {
+	rate = math.Min(math.Max(rate, a.minRate), a.maxRate)
+
+	if rate > a.rate {
+		close(a.changed)
+		a.changed = make(chan struct{})
+	}
+
+	a.rate = rate
+}
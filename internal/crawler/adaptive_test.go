@@ -0,0 +1,261 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveLimiterAIMD(t *testing.T) {
+	limiter := NewLimiter(LimiterConfig{MinRate: 10, MaxRate: 100, MaxTakes: 10}).(*adaptiveLimiter)
+	assert.Equal(t, 10.0, limiter.currentRate())
+
+	// Healthy responses increase the rate additively, by a step per second at the current rate
+	limiter.Feedback(Feedback{StatusCode: 200, Latency: 10 * time.Millisecond})
+	assert.InDelta(t, 10.9, limiter.currentRate(), 0.001)
+
+	for response := 0; response < 1000; response++ {
+		limiter.Feedback(Feedback{StatusCode: 200, Latency: 10 * time.Millisecond})
+	}
+
+	assert.Equal(t, 100.0, limiter.currentRate())
+
+	// Push backs halve the rate, ignoring responses to requests sent before the previous decrease
+	limiter.Feedback(Feedback{StatusCode: http.StatusTooManyRequests, Latency: 10 * time.Millisecond})
+	limiter.Feedback(Feedback{StatusCode: http.StatusTooManyRequests, Latency: 10 * time.Millisecond})
+	assert.Equal(t, 50.0, limiter.currentRate())
+
+	time.Sleep(15 * time.Millisecond)
+	limiter.Feedback(Feedback{StatusCode: http.StatusServiceUnavailable, Latency: 10 * time.Millisecond})
+	assert.Equal(t, 25.0, limiter.currentRate())
+
+	// A single slow response is tolerated, but rising latencies back off as well
+	time.Sleep(60 * time.Millisecond)
+	limiter.Feedback(Feedback{StatusCode: 200, Latency: 50 * time.Millisecond})
+	assert.Greater(t, limiter.currentRate(), 25.0)
+
+	for response := 0; response < 5; response++ {
+		limiter.Feedback(Feedback{StatusCode: 200, Latency: 50 * time.Millisecond})
+	}
+
+	assert.InDelta(t, 12.5, limiter.currentRate(), 0.5)
+
+	// But never below the floor
+	time.Sleep(15 * time.Millisecond)
+	limiter.Feedback(Feedback{Err: fmt.Errorf("connection reset")})
+	assert.Equal(t, 10.0, limiter.currentRate())
+}
+
+func TestAdaptiveLimiterHeaders(t *testing.T) {
+	limiter := NewLimiter(LimiterConfig{MinRate: 2, MaxRate: 100, Rate: 50, MaxTakes: 10}).(*adaptiveLimiter)
+
+	// The rate is capped by the requests remaining in the window
+	header := http.Header{}
+	header.Set("X-RateLimit-Remaining", "30")
+	header.Set("X-RateLimit-Reset", "10")
+	limiter.Feedback(Feedback{StatusCode: 200, Header: header})
+	assert.Equal(t, 3.0, limiter.currentRate())
+
+	// Takes are paused until `Retry-After`
+	assert.Equal(t, true, limiter.Take(context.Background()))
+
+	header = http.Header{}
+	header.Set("Retry-After", "1")
+	limiter.Feedback(Feedback{StatusCode: http.StatusTooManyRequests, Header: header})
+
+	startTime := time.Now()
+	assert.Equal(t, true, limiter.Take(context.Background()))
+	assert.Greater(t, time.Since(startTime), 900*time.Millisecond)
+
+	// Paused takes are released by Close
+	limiter.Feedback(Feedback{StatusCode: http.StatusTooManyRequests, Header: header})
+	time.AfterFunc(10*time.Millisecond, limiter.Close)
+
+	startTime = time.Now()
+	assert.Equal(t, false, limiter.Take(context.Background()))
+	assert.Less(t, time.Since(startTime), 500*time.Millisecond)
+}
+
+func TestAdaptiveLimiterSimulation(t *testing.T) {
+	// The fake server handles 5 requests per 20ms, and throttles the following ones
+	server := newThrottlingServer(5, 20*time.Millisecond)
+	defer server.Close()
+
+	serverURL, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: &rewriteTransport{target: serverURL}}
+
+	writer := &mockWriter{}
+	config := Config{
+		Client: client,
+		Seed:   fakeProfile,
+		Writer: writer,
+		Retry:  RetryPolicy{MaxAttempts: 5, BaseDelay: 20 * time.Millisecond},
+	}
+
+	// Every profile costs 2 requests, so the server sustains 125 profiles per second at most
+	limiterConfig := LimiterConfig{MinRate: 10, MaxRate: 500, MaxTakes: 200}
+	crawler, _ := NewInstagramCrawler(config, limiterConfig)
+
+	stats, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 200, stats.Written)
+
+	// Much faster than the floor would allow, which takes 20s
+	assert.Less(t, stats.Duration, 10*time.Second)
+
+	// The limiter ran into the server capacity, and backed off instead of hammering it
+	requests, throttled := server.counts()
+	assert.Greater(t, throttled, 0)
+	assert.Less(t, float64(throttled), 0.2*float64(requests))
+}
+
+func TestAdaptiveLimiterTransportFailures(t *testing.T) {
+	// The fake server drops every connection without responding
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		_ = conn.Close()
+	}))
+	defer server.Close()
+
+	serverURL, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: &rewriteTransport{target: serverURL}}
+
+	limiter := NewLimiter(LimiterConfig{MinRate: 1, MaxRate: 100, Rate: 80, MaxTakes: 10}).(*adaptiveLimiter)
+	session := newInstagramSession(Config{Client: client, Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}})
+
+	mu := sync.Mutex{}
+	failures := 0
+
+	session.OnFeedback(func(feedback Feedback) {
+		if feedback.Err != nil {
+			mu.Lock()
+			failures++
+			mu.Unlock()
+		}
+
+		limiter.Feedback(feedback)
+	})
+
+	_, err := session.FetchProfileDetail(context.Background(), fakeProfile)
+	assert.NotEqual(t, nil, err)
+
+	// Every attempt is reported, and backs off
+	assert.Equal(t, 3, failures)
+	assert.Equal(t, 10.0, limiter.currentRate())
+
+	// The client of the caller is left untouched
+	assert.IsType(t, &rewriteTransport{}, client.Transport)
+}
+
+/* Private stuffs */
+
+func (a *adaptiveLimiter) currentRate() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.rate
+}
+
+// throttlingServer serves an endless tree of instagram profiles, `capacity` requests per `window` at most
+type throttlingServer struct {
+	*httptest.Server
+	capacity int
+	window   time.Duration
+
+	mu          sync.Mutex
+	windowStart time.Time
+	inWindow    int
+	requests    int
+	throttled   int
+}
+
+func newThrottlingServer(capacity int, window time.Duration) *throttlingServer {
+	server := &throttlingServer{capacity: capacity, window: window}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serve))
+
+	return server
+}
+
+func (s *throttlingServer) counts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests, s.throttled
+}
+
+// allow counts the request, and reports whether it fits in the capacity of the current window
+func (s *throttlingServer) allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.requests++
+
+	if now.Sub(s.windowStart) >= s.window {
+		s.windowStart = now
+		s.inWindow = 0
+	}
+
+	if s.inWindow >= s.capacity {
+		s.throttled++
+		return false
+	}
+
+	s.inWindow++
+	return true
+}
+
+func (s *throttlingServer) serve(w http.ResponseWriter, r *http.Request) {
+	if !s.allow() {
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	var fixture object
+
+	if r.URL.Path == "/graphql/query" {
+		variables := object{}
+		_ = json.Unmarshal([]byte(r.URL.Query().Get("variables")), &variables)
+		id, _ := strconv.Atoi(fmt.Sprint(variables["user_id"]))
+
+		// Every profile suggests 10 new ones
+		related := []string{}
+
+		for child := 1; child <= 10; child++ {
+			related = append(related, strconv.Itoa(id*10+child))
+		}
+
+		fixture = generateRelatedProfilesFixture(related...)
+	} else {
+		username := strings.Trim(r.URL.Path, "/")
+		fixture = generateProfileDetailFixture(strings.TrimPrefix(username, "user_"))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(fixture)
+}
+
+// rewriteTransport sends all requests to `target`, instead of the host of their URL
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+
+	return http.DefaultTransport.RoundTrip(req)
+}
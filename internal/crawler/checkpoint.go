@@ -0,0 +1,292 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Checkpoint records the progress of a crawl, so that an interrupted run can be resumed
+type Checkpoint interface {
+	// Load returns the progress recorded so far
+	Load() (CheckpointState, error)
+	// Reset forgets all recorded progress
+	Reset() error
+	// Pending records profiles scheduled for crawling
+	Pending(profiles ...Profile) error
+	// Started records a profile being crawled
+	Started(profile Profile) error
+	// Completed records a profile which doesn't need to be crawled again
+	Completed(profile Profile) error
+}
+
+// CheckpointState is the progress restored from a Checkpoint
+// @param Pending: profiles scheduled or in-flight when the progress was recorded, in scheduling order
+// @param Completed: profiles crawled, successfully or not
+type CheckpointState struct {
+	Pending   []Profile
+	Completed []Profile
+}
+
+// FileCheckpoint is a Checkpoint journal stored in a local file, safe for concurrent use
+type FileCheckpoint struct {
+	// path: location of the journal
+	// mu: guards the file, which is replaced on compaction
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileCheckpoint opens a Checkpoint journal at `path`, creating it if needed.
+// Every change is appended as a JSON line, so that progress survives crashes of the process.
+func NewFileCheckpoint(path string) (*FileCheckpoint, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileCheckpoint{
+		path: path,
+		file: file,
+	}, nil
+}
+
+// Load replays the journal, then compacts it to the restored state
+func (c *FileCheckpoint) Load() (CheckpointState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	file, err := os.Open(c.path)
+
+	if err != nil {
+		return CheckpointState{}, err
+	}
+
+	state, err := replayCheckpoint(file)
+	file.Close()
+
+	if err != nil {
+		return CheckpointState{}, fmt.Errorf("checkpoint %s: %w", c.path, err)
+	}
+
+	if err := c.compact(state); err != nil {
+		return CheckpointState{}, fmt.Errorf("checkpoint %s: %w", c.path, err)
+	}
+
+	return state, nil
+}
+
+// Reset truncates the journal
+func (c *FileCheckpoint) Reset() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.file.Truncate(0)
+}
+
+// Pending records profiles scheduled for crawling
+func (c *FileCheckpoint) Pending(profiles ...Profile) error {
+	entries := make([]checkpointEntry, 0, len(profiles))
+
+	for _, profile := range profiles {
+		entries = append(entries, newCheckpointEntry(checkpointPending, profile))
+	}
+
+	return c.append(entries...)
+}
+
+// Started records a profile being crawled
+func (c *FileCheckpoint) Started(profile Profile) error {
+	return c.append(newCheckpointEntry(checkpointStarted, profile))
+}
+
+// Completed records a profile which doesn't need to be crawled again
+func (c *FileCheckpoint) Completed(profile Profile) error {
+	return c.append(newCheckpointEntry(checkpointCompleted, profile))
+}
+
+// Close closes the journal file
+func (c *FileCheckpoint) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.file.Close()
+}
+
+/* Private stuffs */
+
+var _ Checkpoint = (*FileCheckpoint)(nil)
+
+// Statuses of a profile in the journal
+const (
+	checkpointPending   = "pending"
+	checkpointStarted   = "started"
+	checkpointCompleted = "completed"
+)
+
+// checkpointEntry is a line of the journal, only identifiers and depth are needed to resume
+type checkpointEntry struct {
+	Status   string `json:"status"`
+	ID       string `json:"id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Depth    int    `json:"depth"`
+}
+
+func newCheckpointEntry(status string, profile Profile) checkpointEntry {
+	return checkpointEntry{
+		Status:   status,
+		ID:       profile.ID,
+		Username: profile.Username,
+		Depth:    profile.Depth,
+	}
+}
+
+func (e checkpointEntry) profile() Profile {
+	return Profile{
+		ID:       e.ID,
+		Username: e.Username,
+		Depth:    e.Depth,
+	}
+}
+
+// append writes entries as a single write, so that concurrent entries are never interleaved
+func (c *FileCheckpoint) append(entries ...checkpointEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	lines := []byte{}
+
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+
+		if err != nil {
+			return err
+		}
+
+		lines = append(append(lines, line...), '\n')
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.file.Write(lines)
+	return err
+}
+
+// compact rewrites the journal with only the entries needed to restore `state`,
+// renaming a temporary file so that a crash never leaves a partial journal
+func (c *FileCheckpoint) compact(state CheckpointState) error {
+	tmpPath := c.path + ".tmp"
+	tmpFile, err := os.Create(tmpPath)
+
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmpFile)
+	encoder := json.NewEncoder(writer)
+
+	for _, profile := range state.Completed {
+		if err := encoder.Encode(newCheckpointEntry(checkpointCompleted, profile)); err != nil {
+			tmpFile.Close()
+			return err
+		}
+	}
+
+	for _, profile := range state.Pending {
+		if err := encoder.Encode(newCheckpointEntry(checkpointPending, profile)); err != nil {
+			tmpFile.Close()
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, c.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(c.path, os.O_WRONLY|os.O_APPEND, 0o644)
+
+	if err != nil {
+		return err
+	}
+
+	c.file.Close()
+	c.file = file
+
+	return nil
+}
+
+// replayCheckpoint restores the latest status of every profile, keeping the order they were first seen
+func replayCheckpoint(reader io.Reader) (CheckpointState, error) {
+	keys := []string{}
+	entries := map[string]checkpointEntry{}
+
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		entry := checkpointEntry{}
+
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A line partially written by a crash is skipped
+			if isSyntaxError(err) {
+				continue
+			}
+
+			return CheckpointState{}, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		key := visitedKey(entry.profile())
+		previous, ok := entries[key]
+
+		if !ok {
+			keys = append(keys, key)
+		}
+
+		// A completed profile stays completed, even if it is scheduled again by another run
+		if ok && previous.Status == checkpointCompleted {
+			continue
+		}
+
+		entries[key] = entry
+	}
+
+	if err := scanner.Err(); err != nil {
+		return CheckpointState{}, err
+	}
+
+	state := CheckpointState{}
+
+	for _, key := range keys {
+		entry := entries[key]
+
+		if entry.Status == checkpointCompleted {
+			state.Completed = append(state.Completed, entry.profile())
+		} else {
+			state.Pending = append(state.Pending, entry.profile())
+		}
+	}
+
+	return state, nil
+}
+
+func isSyntaxError(err error) bool {
+	syntaxErr := &json.SyntaxError{}
+	return errors.As(err, &syntaxErr)
+}
@@ -0,0 +1,84 @@
+package crawler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	checkpoint, err := NewFileCheckpoint(path)
+	assert.Equal(t, nil, err)
+	defer checkpoint.Close()
+
+	state, err := checkpoint.Load()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, CheckpointState{}, state)
+
+	assert.Equal(t, nil, checkpoint.Pending(Profile{ID: "1"}))
+	assert.Equal(t, nil, checkpoint.Started(Profile{ID: "1"}))
+	assert.Equal(t, nil, checkpoint.Pending(Profile{ID: "2", Depth: 1}, Profile{Username: "user_3", Depth: 1}))
+	assert.Equal(t, nil, checkpoint.Completed(Profile{ID: "1"}))
+	assert.Equal(t, nil, checkpoint.Started(Profile{ID: "2", Depth: 1}))
+
+	// Completed profiles are never pending again
+	assert.Equal(t, nil, checkpoint.Pending(Profile{ID: "1", Depth: 2}))
+
+	state, err = checkpoint.Load()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Profile{{ID: "1"}}, state.Completed)
+	assert.Equal(t, []Profile{{ID: "2", Depth: 1}, {Username: "user_3", Depth: 1}}, state.Pending)
+
+	// Loading compacts the journal, which keeps accepting entries
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, 3, strings.Count(string(content), "\n"))
+
+	assert.Equal(t, nil, checkpoint.Completed(Profile{ID: "2", Depth: 1}))
+
+	state, err = checkpoint.Load()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Profile{{ID: "1"}, {ID: "2", Depth: 1}}, state.Completed)
+	assert.Equal(t, []Profile{{Username: "user_3", Depth: 1}}, state.Pending)
+
+	assert.Equal(t, nil, checkpoint.Reset())
+
+	state, err = checkpoint.Load()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, CheckpointState{}, state)
+}
+
+func TestFileCheckpointPartialLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	journal := `{"status":"pending","id":"1","depth":0}
+{"status":"completed","id":"1","depth":0}
+{"status":"pending","id":"2","depth":1}
+{"status":"compl`
+	assert.Equal(t, nil, os.WriteFile(path, []byte(journal), 0o644))
+
+	checkpoint, err := NewFileCheckpoint(path)
+	assert.Equal(t, nil, err)
+	defer checkpoint.Close()
+
+	state, err := checkpoint.Load()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Profile{{ID: "1"}}, state.Completed)
+	assert.Equal(t, []Profile{{ID: "2", Depth: 1}}, state.Pending)
+}
+
+func TestFileCheckpointErrors(t *testing.T) {
+	_, err := NewFileCheckpoint(filepath.Join(t.TempDir(), "missing", "checkpoint.jsonl"))
+	assert.NotEqual(t, nil, err)
+
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	assert.Equal(t, nil, os.WriteFile(path, []byte(`{"status": 1}`+"\n"), 0o644))
+
+	checkpoint, _ := NewFileCheckpoint(path)
+	defer checkpoint.Close()
+
+	_, err = checkpoint.Load()
+	assert.Contains(t, err.Error(), "checkpoint.jsonl: line 1: json: cannot unmarshal number")
+}
@@ -1,13 +1,18 @@
 package crawler
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 // Crawler represents a crawler instance
 type Crawler interface {
-	Run()
+	// Run crawls until the limiter budget is exhausted or `ctx` is cancelled,
+	// in-flight jobs are always drained before returning.
+	// A crawler runs once, following calls return ErrAlreadyRun.
+	Run(ctx context.Context) (RunStats, error)
 }
 
 // Profile provides information of a user
@@ -15,6 +20,7 @@ type Profile struct {
 	fmt.Stringer
 	Source      string
 	AvatarURL   string
+	Depth       int
 	DisplayName string
 	Gallery     []string
 	ID          string
@@ -31,19 +37,86 @@ func (p Profile) String() string {
 	return fmt.Sprintf("<%s %s %s %s>", source, p.ID, p.Username, p.DisplayName)
 }
 
-// Writer provides interfaces to output profiles
+// Source provides interfaces to fetch profiles from a social network
+type Source interface {
+	FetchProfileDetail(context.Context, Profile) (Profile, error)
+	FetchRelatedProfiles(context.Context, Profile) ([]Profile, error)
+}
+
+// Writer provides interfaces to output profiles.
+// Failed writes are counted and skipped, unless the error matches ErrFatalWrite which aborts the run.
+// Writers implementing Flusher are flushed every `FlushInterval`, writers implementing io.Closer are
+// flushed and closed once the run ends, including when it is cancelled or panics.
 type Writer interface {
 	Write(Profile) error
 }
 
+// Flusher is implemented by writers buffering their output
+type Flusher interface {
+	Flush() error
+}
+
+// Edge is a relationship between two profiles: `From` suggested `To`
+// @param From: the source profile, which was crawled
+// @param To: the target profile, which may never be crawled, e.g. if already visited or beyond MaxDepth
+// @param Depth: hops from the seed to `From`
+// @param Timestamp: when the relationship was discovered
+type Edge struct {
+	From      Profile
+	To        Profile
+	Depth     int
+	Timestamp time.Time
+}
+
+// EdgeWriter is implemented by writers also able to output the relationships between profiles.
+// Edges are written from the same goroutine as profiles, including edges to profiles already visited.
+type EdgeWriter interface {
+	WriteEdge(Edge) error
+}
+
 // Config holds configurations for the crawler
+// @param Checkpoint: progress journal, the run isn't resumable if `nil`
 // @param Client: HTTP client, auto initialise with `resty.New()` if `nil`
+// @param Credentials: session ID provider, takes precedence over `SessionID`
+// @param FlushInterval: time between flushes of `Writer` if it is a `Flusher`, only flushed once the run ends if `0`
+// @param MaxConcurrency: maximum crawl jobs in flight at once, whatever the limiter allows, unlimited if `0`
+// @param MaxDepth: maximum hops from the seed to crawl, unlimited if `0`
+// @param Resume: restores the frontier and visited profiles from `Checkpoint` instead of starting from the seeds
+// @param Retry: retry policy of the source requests, no retries by default
 // @param Seed: the initial profile to start crawling with
-// @param SessionID: cookie session ID
-// @param Writer: writing stream
+// @param Seeds: additional initial profiles, sharing visited profiles and limits with `Seed`
+// @param SessionID: cookie session ID, redacted from logs and `%v` outputs
+// @param Strategy: crawl order of discovered profiles, `BreadthFirst` by default
+// @param Visited: visited profiles set, auto initialise with `NewMemoryVisitedSet()` for every run if `nil`
+// @param WriteBuffer: profiles and edge batches buffered for `Writer`, crawl jobs block once it is full, unbuffered if `0`
+// @param Writer: writing stream, also receives edges if it implements `EdgeWriter`
 type Config struct {
-	Client    *http.Client
-	Seed      Profile
-	SessionID string
-	Writer    Writer
+	Checkpoint     Checkpoint
+	Client         *http.Client
+	Credentials    CredentialsProvider
+	FlushInterval  time.Duration
+	MaxConcurrency int
+	MaxDepth       int
+	Resume         bool
+	Retry          RetryPolicy
+	Seed           Profile
+	Seeds          []Profile
+	SessionID      Secret
+	Strategy       Strategy
+	Visited        VisitedSet
+	WriteBuffer    int
+	Writer         Writer
+}
+
+/* Private stuffs */
+
+// seeds returns all initial profiles, `Seed` first if set
+func (c Config) seeds() []Profile {
+	seeds := []Profile{}
+
+	if c.Seed.ID != "" || c.Seed.Username != "" {
+		seeds = append(seeds, c.Seed)
+	}
+
+	return append(seeds, c.Seeds...)
 }
@@ -0,0 +1,207 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Secret holds a sensitive value, which is redacted whenever it is printed, logged or serialised
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+
+	return "[REDACTED]"
+}
+
+// GoString redacts the secret from `%#v` outputs
+func (s Secret) GoString() string {
+	return fmt.Sprintf("%q", s.String())
+}
+
+// MarshalText redacts the secret from JSON, YAML and other text encodings
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Reveal returns the raw value, it must only be used to authenticate requests
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// CredentialsProvider supplies the session ID used to authenticate against a source
+type CredentialsProvider interface {
+	SessionID() (Secret, error)
+}
+
+// StaticCredentials provides a fixed session ID
+type StaticCredentials Secret
+
+func (c StaticCredentials) String() string {
+	return Secret(c).String()
+}
+
+// GoString redacts the session ID from `%#v` outputs
+func (c StaticCredentials) GoString() string {
+	return Secret(c).GoString()
+}
+
+// MarshalText redacts the session ID from JSON, YAML and other text encodings
+func (c StaticCredentials) MarshalText() ([]byte, error) {
+	return Secret(c).MarshalText()
+}
+
+// SessionID returns the fixed session ID, or fails if it's empty
+func (c StaticCredentials) SessionID() (Secret, error) {
+	if c == "" {
+		return "", errors.New("static session ID is empty")
+	}
+
+	return Secret(c), nil
+}
+
+// EnvCredentials reads the session ID from an env var
+type EnvCredentials struct {
+	Key string
+}
+
+// SessionID reads the env var, or fails if it's unset or empty
+func (c EnvCredentials) SessionID() (Secret, error) {
+	value := strings.TrimSpace(os.Getenv(c.Key))
+
+	if value == "" {
+		return "", fmt.Errorf("env var %s is empty", c.Key)
+	}
+
+	return Secret(value), nil
+}
+
+// FileCredentials reads the session ID from a file, surrounding whitespaces are trimmed.
+// The file is read again on every call, so that the session can be rotated without restarting.
+type FileCredentials struct {
+	Path string
+}
+
+// SessionID reads the file, or fails if it's missing or empty
+func (c FileCredentials) SessionID() (Secret, error) {
+	content, err := os.ReadFile(c.Path)
+
+	if err != nil {
+		return "", fmt.Errorf("reading session ID file failed: %w", err)
+	}
+
+	value := strings.TrimSpace(string(content))
+
+	if value == "" {
+		return "", fmt.Errorf("session ID file %s is empty", c.Path)
+	}
+
+	return Secret(value), nil
+}
+
+// DockerSecretCredentials reads the session ID from a Docker secret mounted under `/run/secrets`
+func DockerSecretCredentials(name string) FileCredentials {
+	return FileCredentials{Path: filepath.Join(dockerSecretsDir, name)}
+}
+
+// Keyring abstracts an OS keyring, storing secrets by service and account
+type Keyring interface {
+	Get(service string, account string) (string, error)
+}
+
+// ErrKeyNotFound is returned by a Keyring when no secret is stored for a service and account
+var ErrKeyNotFound = errors.New("secret not found in keyring")
+
+// NewMemoryKeyring creates an in-memory Keyring, standing in for an OS keyring
+func NewMemoryKeyring() *MemoryKeyring {
+	return &MemoryKeyring{secrets: map[string]string{}}
+}
+
+// MemoryKeyring is an in-memory Keyring, safe for concurrent use
+type MemoryKeyring struct {
+	mu      sync.RWMutex
+	secrets map[string]string
+}
+
+// Get returns the secret stored for `service` and `account`, or ErrKeyNotFound
+func (k *MemoryKeyring) Get(service string, account string) (string, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	secret, ok := k.secrets[service+"/"+account]
+
+	if !ok {
+		return "", ErrKeyNotFound
+	}
+
+	return secret, nil
+}
+
+// Set stores a secret for `service` and `account`
+func (k *MemoryKeyring) Set(service string, account string, secret string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.secrets[service+"/"+account] = secret
+}
+
+// KeyringCredentials reads the session ID from a Keyring
+type KeyringCredentials struct {
+	Keyring Keyring
+	Service string
+	Account string
+}
+
+// SessionID looks up the keyring
+func (c KeyringCredentials) SessionID() (Secret, error) {
+	value, err := c.Keyring.Get(c.Service, c.Account)
+
+	if err != nil {
+		return "", fmt.Errorf("reading keyring %s/%s failed: %w", c.Service, c.Account, err)
+	}
+
+	return Secret(value), nil
+}
+
+// ChainCredentials tries every provider in order, and returns the first session ID found
+type ChainCredentials []CredentialsProvider
+
+// SessionID returns the first session ID found, or all errors if none of the providers succeeded
+func (c ChainCredentials) SessionID() (Secret, error) {
+	if len(c) == 0 {
+		return "", errors.New("no credentials provider configured")
+	}
+
+	messages := []string{}
+
+	for _, provider := range c {
+		sessionID, err := provider.SessionID()
+
+		if err == nil {
+			return sessionID, nil
+		}
+
+		messages = append(messages, err.Error())
+	}
+
+	return "", fmt.Errorf("no session ID found: %s", strings.Join(messages, "; "))
+}
+
+/* Private stuffs */
+
+var (
+	_ CredentialsProvider = StaticCredentials("")
+	_ CredentialsProvider = EnvCredentials{}
+	_ CredentialsProvider = FileCredentials{}
+	_ CredentialsProvider = KeyringCredentials{}
+	_ CredentialsProvider = ChainCredentials{}
+	_ Keyring             = (*MemoryKeyring)(nil)
+)
+
+const dockerSecretsDir = "/run/secrets"
@@ -0,0 +1,137 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecret(t *testing.T) {
+	secret := Secret("fake-session-id")
+
+	assert.Equal(t, "[REDACTED]", secret.String())
+	assert.Equal(t, "fake-session-id", secret.Reveal())
+	assert.Equal(t, "", Secret("").String())
+
+	config := Config{SessionID: secret}
+
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		assert.NotContains(t, fmt.Sprintf(format, config), "fake-session-id", format)
+	}
+
+	encoded, _ := json.Marshal(config)
+	assert.NotContains(t, string(encoded), "fake-session-id")
+
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	logger.WithField("config", config).Info("crawling")
+
+	entry, _ := hook.LastEntry().String()
+	assert.NotContains(t, entry, "fake-session-id")
+}
+
+func TestStaticCredentials(t *testing.T) {
+	sessionID, err := StaticCredentials("fake").SessionID()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, Secret("fake"), sessionID)
+
+	_, err = StaticCredentials("").SessionID()
+	assert.EqualError(t, err, "static session ID is empty")
+
+	// The session ID is redacted however the credentials are printed, alone or within a config
+	credentials := StaticCredentials("fake-session-id")
+	config := Config{Credentials: credentials}
+
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		assert.NotContains(t, fmt.Sprintf(format, credentials), "fake-session-id", format)
+		assert.NotContains(t, fmt.Sprintf(format, config), "fake-session-id", format)
+	}
+
+	encoded, _ := json.Marshal(credentials)
+	assert.NotContains(t, string(encoded), "fake-session-id")
+
+	logger, hook := test.NewNullLogger()
+	logger.WithField("credentials", credentials).Info("crawling")
+
+	entry, _ := hook.LastEntry().String()
+	assert.NotContains(t, entry, "fake-session-id")
+}
+
+func TestEnvCredentials(t *testing.T) {
+	provider := EnvCredentials{Key: "NSFW_TEST_SESSION_ID"}
+
+	_, err := provider.SessionID()
+	assert.EqualError(t, err, "env var NSFW_TEST_SESSION_ID is empty")
+
+	os.Setenv("NSFW_TEST_SESSION_ID", " fake \n")
+	defer os.Unsetenv("NSFW_TEST_SESSION_ID")
+
+	sessionID, err := provider.SessionID()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "fake", sessionID.Reveal())
+}
+
+func TestFileCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session_id")
+	provider := FileCredentials{Path: path}
+
+	_, err := provider.SessionID()
+	assert.NotEqual(t, nil, err)
+
+	_ = os.WriteFile(path, []byte("\n"), 0600)
+	_, err = provider.SessionID()
+	assert.EqualError(t, err, fmt.Sprintf("session ID file %s is empty", path))
+
+	_ = os.WriteFile(path, []byte("fake\n"), 0600)
+	sessionID, err := provider.SessionID()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "fake", sessionID.Reveal())
+
+	// Rotated sessions are picked up without recreating the provider
+	_ = os.WriteFile(path, []byte("rotated"), 0600)
+	sessionID, _ = provider.SessionID()
+	assert.Equal(t, "rotated", sessionID.Reveal())
+}
+
+func TestDockerSecretCredentials(t *testing.T) {
+	assert.Equal(t, "/run/secrets/instagram_session_id", DockerSecretCredentials("instagram_session_id").Path)
+}
+
+func TestKeyringCredentials(t *testing.T) {
+	keyring := NewMemoryKeyring()
+	provider := KeyringCredentials{Keyring: keyring, Service: "instagram", Account: "crawler"}
+
+	_, err := provider.SessionID()
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	keyring.Set("instagram", "crawler", "fake")
+	sessionID, err := provider.SessionID()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "fake", sessionID.Reveal())
+}
+
+func TestChainCredentials(t *testing.T) {
+	_, err := ChainCredentials{}.SessionID()
+	assert.EqualError(t, err, "no credentials provider configured")
+
+	chain := ChainCredentials{
+		EnvCredentials{Key: "NSFW_TEST_MISSING"},
+		StaticCredentials("fake"),
+	}
+	sessionID, err := chain.SessionID()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "fake", sessionID.Reveal())
+
+	chain = ChainCredentials{
+		EnvCredentials{Key: "NSFW_TEST_MISSING"},
+		StaticCredentials(""),
+	}
+	_, err = chain.SessionID()
+	assert.EqualError(t, err, "no session ID found: env var NSFW_TEST_MISSING is empty; static session ID is empty")
+}
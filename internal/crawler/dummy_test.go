@@ -1,6 +1,7 @@
 package crawler
 
 import (
+	"context"
 	"errors"
 	"testing"
 
@@ -34,7 +35,7 @@ func TestDummyCrawlerFailure(t *testing.T) {
 	}
 
 	crawler, _ := NewDummyCrawler(config, limiterConfig)
-	assert.NotPanics(t, crawler.Run)
+	assert.NotPanics(t, func() { _, _ = crawler.Run(context.Background()) })
 }
 
 func TestDummyCrawlerSuccess(t *testing.T) {
@@ -47,7 +48,7 @@ func TestDummyCrawlerSuccess(t *testing.T) {
 	}
 
 	crawler, _ := NewDummyCrawler(config, limiterConfig)
-	assert.NotPanics(t, crawler.Run)
+	assert.NotPanics(t, func() { _, _ = crawler.Run(context.Background()) })
 }
 
 /* Private stuffs */
@@ -0,0 +1,752 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NewCrawler creates a crawler which walks through profiles provided by `source`
+func NewCrawler(source Source, config Config, limiterConfig LimiterConfig) (Crawler, error) {
+	if source == nil {
+		return nil, errors.New("missing required Source")
+	}
+
+	if len(config.seeds()) == 0 {
+		return nil, errors.New("missing required Seed config")
+	}
+
+	for idx, seed := range config.Seeds {
+		if seed.Username == "" && seed.ID == "" {
+			return nil, fmt.Errorf("invalid Seeds config: seed #%d has neither ID nor Username", idx)
+		}
+	}
+
+	if config.Writer == nil {
+		return nil, errors.New("missing required Writer config")
+	}
+
+	if config.WriteBuffer < 0 {
+		return nil, errors.New("invalid WriteBuffer config: must not be negative")
+	}
+
+	if config.MaxConcurrency < 0 {
+		return nil, errors.New("invalid MaxConcurrency config: must not be negative")
+	}
+
+	if config.Resume && config.Checkpoint == nil {
+		return nil, errors.New("invalid Resume config: missing required Checkpoint config")
+	}
+
+	return &engine{
+		config:        config,
+		limiterConfig: limiterConfig,
+		source:        source,
+	}, nil
+}
+
+// Run crawls until the frontier is exhausted, the limiter runs out of takes or `parentCtx` is done.
+// The Writer is flushed and closed when it ends, even on panics, so the crawler serves a single run.
+func (e *engine) Run(parentCtx context.Context) (stats RunStats, err error) {
+	e.mu.Lock()
+	alreadyRun := e.alreadyRun
+	e.alreadyRun = true
+	e.mu.Unlock()
+
+	if alreadyRun {
+		return RunStats{}, ErrAlreadyRun
+	}
+
+	defer func() {
+		if closeErr := e.closeWriter(); closeErr != nil && err == nil {
+			err = fmt.Errorf("closing writer failed: %w", closeErr)
+		}
+	}()
+
+	return e.run(parentCtx)
+}
+
+/* Private stuffs */
+
+func (e *engine) run(parentCtx context.Context) (RunStats, error) {
+	startTime := time.Now()
+	initialSourceStats := sourceStats(e.source)
+
+	// Cancelled once the writes stage stops, even on a Writer panic, to release jobs blocked on a full queue
+	ctx, cancel := context.WithCancel(parentCtx)
+	defer cancel()
+
+	e.abortErr = nil
+	e.abortOnce = &sync.Once{}
+	e.cancel = cancel
+	e.requeues = map[string]int{}
+
+	e.frontier = newFrontier(e.config.Strategy)
+	e.jobsWg = &sync.WaitGroup{}
+
+	if e.config.MaxConcurrency > 0 {
+		e.jobSlots = make(chan struct{}, e.config.MaxConcurrency)
+	}
+
+	e.limiter = NewLimiter(e.limiterConfig)
+	e.connectFeedback()
+
+	e.writesQueue = make(chan writeJob, e.config.WriteBuffer)
+	e.stats = &statsRecorder{}
+	e.visited = e.config.Visited
+
+	if e.visited == nil {
+		e.visited = NewMemoryVisitedSet()
+	}
+
+	if err := e.restore(); err != nil {
+		return e.stats.snapshot(), fmt.Errorf("restoring checkpoint failed: %w", err)
+	}
+
+	go func() {
+		e.dispatch(ctx)
+
+		e.jobsWg.Wait()
+		e.limiter.Close()
+
+		close(e.writesQueue)
+	}()
+
+	// Release the dispatcher blocked on the frontier as soon as the run is cancelled, `Take` returns on its own
+	done := make(chan struct{})
+	watcherWg := &sync.WaitGroup{}
+	watcherWg.Add(1)
+
+	go func() {
+		defer watcherWg.Done()
+
+		select {
+		case <-ctx.Done():
+			logrus.WithField("buffered_writes", len(e.writesQueue)).Info("crawl cancelled, draining in-flight jobs")
+			e.frontier.close()
+		case <-done:
+		}
+	}()
+
+	e.consumeWrites()
+
+	close(done)
+	watcherWg.Wait()
+
+	finalSourceStats := sourceStats(e.source)
+
+	stats := e.stats.snapshot()
+	stats.Pending = append(stats.Pending, e.frontier.drain()...)
+	stats.BytesDownloaded = finalSourceStats.BytesDownloaded - initialSourceStats.BytesDownloaded
+	stats.Retries = finalSourceStats.Retries - initialSourceStats.Retries
+	stats.Duration = time.Since(startTime)
+
+	if e.abortErr != nil {
+		return stats, fmt.Errorf("crawl aborted: %w", e.abortErr)
+	}
+
+	if err := parentCtx.Err(); err != nil {
+		return stats, fmt.Errorf("crawl cancelled: %w", err)
+	}
+
+	return stats, nil
+}
+
+var _ Crawler = (*engine)(nil)
+
+// maxRequeues limits how many times a rate limited profile is put back to the frontier
+const maxRequeues = 3
+
+type engine struct {
+	// Received configurations
+	config        Config
+	limiterConfig LimiterConfig
+	source        Source
+
+	// frontier: profiles discovered but not crawled yet
+	// jobsWg: wait group for crawl jobs
+	// jobSlots: semaphore of crawl jobs in flight, up to `MaxConcurrency`, unlimited if `nil`
+	// writesQueue: crawled profiles and edges waiting to be written, up to `WriteBuffer` jobs
+	// stats: metrics of the current run
+	// visited: profiles already scheduled for crawling
+	frontier    *frontier
+	jobsWg      *sync.WaitGroup
+	jobSlots    chan struct{}
+	limiter     Limiter
+	writesQueue chan writeJob
+	stats       *statsRecorder
+	visited     VisitedSet
+
+	// abortErr: the error which aborted the run, written once before `cancel`
+	// requeues: amount of times every profile was put back to the frontier, guarded by `mu`
+	// alreadyRun: set by the first Run, guarded by `mu`
+	abortErr   error
+	abortOnce  *sync.Once
+	cancel     context.CancelFunc
+	mu         sync.Mutex
+	requeues   map[string]int
+	alreadyRun bool
+}
+
+// writeJob holds either a crawled profile or the edges to its related profiles
+type writeJob struct {
+	profile *Profile
+	edges   []Edge
+}
+
+// dispatch starts a crawl job for every profile popped from the frontier,
+// until the frontier is exhausted, the limiter runs out of takes or the run is cancelled.
+// A job slot is acquired before taking, so that jobs waiting for a slot don't use up the rate.
+func (e *engine) dispatch(ctx context.Context) {
+	for e.frontier.wait() {
+		if !e.acquireJobSlot(ctx) {
+			return
+		}
+
+		if !e.limiter.Take(ctx) {
+			if ctx.Err() == nil {
+				logrus.Info("max takes reached")
+			}
+
+			e.releaseJobSlot()
+			return
+		}
+
+		profile, ok := e.frontier.pop()
+
+		if !ok {
+			e.limiter.Release()
+			e.releaseJobSlot()
+			return
+		}
+
+		e.checkpoint(profile, func(checkpoint Checkpoint) error { return checkpoint.Started(profile) })
+
+		e.jobsWg.Add(1)
+		go e.crawl(ctx, profile)
+	}
+}
+
+// acquireJobSlot blocks until less than `MaxConcurrency` jobs are in flight, or returns `false` once `ctx` is done
+func (e *engine) acquireJobSlot(ctx context.Context) bool {
+	if e.jobSlots == nil {
+		return true
+	}
+
+	select {
+	case e.jobSlots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// releaseJobSlot frees the slot of a finished job
+func (e *engine) releaseJobSlot() {
+	if e.jobSlots != nil {
+		<-e.jobSlots
+	}
+}
+
+// crawl fetches the profile and its related profiles, then schedules the newly discovered ones.
+// The slot reserved by the take is committed once the profile is fetched, and released otherwise,
+// so that only fetched profiles count towards max takes.
+func (e *engine) crawl(ctx context.Context, profile Profile) {
+	committed := false
+
+	defer e.jobsWg.Done()
+	defer e.releaseJobSlot()
+	defer e.frontier.done()
+	defer func() {
+		if !committed {
+			e.limiter.Release()
+		}
+	}()
+	defer e.recoverJob(profile)
+
+	logrus.WithFields(logrus.Fields{
+		"profile": profile,
+		"depth":   profile.Depth,
+		"time":    time.Now().Format("15:04:05.000"),
+	}).Info("crawling")
+
+	profileDetail, err := e.source.FetchProfileDetail(ctx, profile)
+
+	if err != nil {
+		if e.detailFailed(ctx, profile, err) {
+			e.complete(profile)
+		}
+
+		return
+	}
+
+	profileDetail.Depth = profile.Depth
+
+	// A profile seeded by username is recognised later on, when suggested back by its ID
+	if profile.ID == "" && profileDetail.ID != "" {
+		e.markVisited(profileDetail)
+	}
+
+	e.stats.record(func(stats *RunStats) {
+		stats.Fetched++
+
+		if profile.Depth > stats.MaxDepth {
+			stats.MaxDepth = profile.Depth
+		}
+	})
+
+	e.limiter.Commit()
+	committed = true
+
+	if !e.enqueueWrite(ctx, writeJob{profile: &profileDetail}) {
+		e.stats.record(func(stats *RunStats) { stats.Pending = append(stats.Pending, profile) })
+		logrus.WithField("profile", profile).Debug("write interrupted, profile kept pending")
+		return
+	}
+
+	if e.config.MaxDepth > 0 && profile.Depth >= e.config.MaxDepth {
+		e.complete(profile)
+		return
+	}
+
+	relatedProfiles, err := e.source.FetchRelatedProfiles(ctx, profileDetail)
+
+	if err != nil && ctx.Err() != nil {
+		logrus.WithFields(logrus.Fields{
+			"profile": profile,
+			"error":   err,
+		}).Debug("FetchRelatedProfiles interrupted")
+		return
+	}
+
+	if err != nil {
+		e.stats.record(func(stats *RunStats) { stats.RelatedFailures++ })
+
+		logrus.WithFields(logrus.Fields{
+			"profile": profile,
+			"error":   err,
+		}).Error("FetchRelatedProfiles failed")
+
+		if errors.Is(err, ErrUnauthorized) {
+			e.abort(err)
+			return
+		}
+
+		e.complete(profile)
+		return
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	if _, ok := e.config.Writer.(EdgeWriter); ok && len(relatedProfiles) > 0 {
+		edges := make([]Edge, 0, len(relatedProfiles))
+		timestamp := time.Now()
+
+		for _, relatedProfile := range relatedProfiles {
+			edges = append(edges, Edge{
+				From:      profileDetail,
+				To:        relatedProfile,
+				Depth:     profile.Depth,
+				Timestamp: timestamp,
+			})
+		}
+
+		if !e.enqueueWrite(ctx, writeJob{edges: edges}) {
+			return
+		}
+	}
+
+	discoveredProfiles := []Profile{}
+
+	for _, relatedProfile := range relatedProfiles {
+		if !e.visit(relatedProfile) {
+			continue
+		}
+
+		relatedProfile.Depth = profile.Depth + 1
+		discoveredProfiles = append(discoveredProfiles, relatedProfile)
+	}
+
+	e.schedule(discoveredProfiles...)
+	e.complete(profile)
+}
+
+// detailFailed reacts to a failed profile fetch according to the error:
+// skips deleted profiles, requeues rate limited ones and aborts the run on authentication failures.
+// Profiles interrupted by the end of the run are kept pending instead of being counted as failures.
+// It reports whether the profile is settled, or has to be crawled again.
+func (e *engine) detailFailed(ctx context.Context, profile Profile, err error) bool {
+	fields := logrus.Fields{
+		"profile": profile,
+		"error":   err,
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		e.stats.record(func(stats *RunStats) { stats.Pending = append(stats.Pending, profile) })
+		logrus.WithFields(fields).Debug("FetchProfileDetail interrupted, profile kept pending")
+		return false
+
+	case errors.Is(err, ErrUnauthorized):
+		e.stats.record(func(stats *RunStats) {
+			stats.DetailFailures++
+			stats.Pending = append(stats.Pending, profile)
+		})
+		logrus.WithFields(fields).Error("FetchProfileDetail unauthorized")
+		e.abort(err)
+		return false
+
+	case errors.Is(err, ErrNotFound):
+		e.stats.record(func(stats *RunStats) { stats.NotFound++ })
+		logrus.WithFields(fields).Info("profile not found, skipping")
+		return true
+
+	case errors.Is(err, ErrRateLimited) && e.requeue(profile):
+		e.stats.record(func(stats *RunStats) { stats.Requeued++ })
+		logrus.WithFields(fields).Warn("rate limited, profile requeued")
+		return false
+
+	default:
+		e.stats.record(func(stats *RunStats) { stats.DetailFailures++ })
+		logrus.WithFields(fields).Error("FetchProfileDetail failed")
+		return true
+	}
+}
+
+// requeue puts the profile back to the frontier, unless it was requeued `maxRequeues` times already
+func (e *engine) requeue(profile Profile) bool {
+	key := visitedKey(profile)
+
+	e.mu.Lock()
+
+	if e.requeues[key] >= maxRequeues {
+		e.mu.Unlock()
+		return false
+	}
+
+	e.requeues[key]++
+	e.mu.Unlock()
+
+	e.schedule(profile)
+	return true
+}
+
+// restore fills the frontier from the checkpoint when resuming, or from the seeds otherwise.
+// A resumed run without any recorded progress starts from the seeds as well.
+func (e *engine) restore() error {
+	if e.config.Checkpoint != nil && e.config.Resume {
+		state, err := e.config.Checkpoint.Load()
+
+		if err != nil {
+			return err
+		}
+
+		if len(state.Pending) > 0 || len(state.Completed) > 0 {
+			for _, profile := range state.Completed {
+				e.visit(profile)
+			}
+
+			for _, profile := range state.Pending {
+				if e.visit(profile) {
+					e.frontier.push(profile)
+				}
+			}
+
+			logrus.WithFields(logrus.Fields{
+				"pending":   len(state.Pending),
+				"completed": len(state.Completed),
+			}).Info("crawl resumed from checkpoint")
+			return nil
+		}
+	} else if e.config.Checkpoint != nil {
+		if err := e.config.Checkpoint.Reset(); err != nil {
+			return err
+		}
+	}
+
+	seeds := []Profile{}
+
+	for _, seed := range e.config.seeds() {
+		seed.Depth = 0
+
+		if e.visit(seed) {
+			seeds = append(seeds, seed)
+		}
+	}
+
+	e.schedule(seeds...)
+	return nil
+}
+
+// schedule records profiles as pending in the checkpoint, then pushes them to the frontier
+func (e *engine) schedule(profiles ...Profile) {
+	if len(profiles) == 0 {
+		return
+	}
+
+	e.checkpoint(profiles[0], func(checkpoint Checkpoint) error { return checkpoint.Pending(profiles...) })
+	e.frontier.push(profiles...)
+}
+
+// complete records a profile as completed in the checkpoint
+func (e *engine) complete(profile Profile) {
+	e.checkpoint(profile, func(checkpoint Checkpoint) error { return checkpoint.Completed(profile) })
+}
+
+// checkpoint applies `update` to the configured checkpoint, failures only cost resumability so they are logged
+func (e *engine) checkpoint(profile Profile, update func(Checkpoint) error) {
+	if e.config.Checkpoint == nil {
+		return
+	}
+
+	if err := update(e.config.Checkpoint); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"profile": profile,
+			"error":   err,
+		}).Error("updating checkpoint failed")
+	}
+}
+
+// connectFeedback reports the responses of the source to the limiter, if it adapts its rate to them
+func (e *engine) connectFeedback() {
+	receiver, ok := e.limiter.(FeedbackReceiver)
+
+	if !ok {
+		return
+	}
+
+	reporter, ok := e.source.(FeedbackReporter)
+
+	if !ok {
+		logrus.Warn("source doesn't report its responses, the adaptive limiter keeps its initial rate")
+		return
+	}
+
+	reporter.OnFeedback(receiver.Feedback)
+}
+
+// abort stops the run as soon as possible, only the first error is kept
+func (e *engine) abort(err error) {
+	e.abortOnce.Do(func() {
+		logrus.WithField("error", err).Error("aborting crawl")
+
+		e.abortErr = err
+		e.cancel()
+	})
+}
+
+// recoverJob aborts the run if the crawl job panicked, so that it ends normally and closes the Writer.
+// The profile is kept pending, to be crawled again once the bug is fixed.
+func (e *engine) recoverJob(profile Profile) {
+	recovered := recover()
+
+	if recovered == nil {
+		return
+	}
+
+	e.stats.record(func(stats *RunStats) { stats.Pending = append(stats.Pending, profile) })
+
+	logrus.WithFields(logrus.Fields{
+		"profile": profile,
+		"panic":   recovered,
+		"stack":   string(debug.Stack()),
+	}).Error("crawl job panicked")
+
+	e.abort(fmt.Errorf("crawl job panicked: %v", recovered))
+}
+
+// consumeWrites writes jobs until the writes queue is closed, flushing the Writer every `FlushInterval`.
+// Flushes happen on the same goroutine as writes, so that writers don't need to be thread-safe.
+func (e *engine) consumeWrites() {
+	var tick <-chan time.Time
+
+	if _, ok := e.config.Writer.(Flusher); ok && e.config.FlushInterval > 0 {
+		ticker := time.NewTicker(e.config.FlushInterval)
+		defer ticker.Stop()
+
+		tick = ticker.C
+	}
+
+	flush := func() {
+		if err := e.flushWriter(); errors.Is(err, ErrFatalWrite) {
+			e.abort(err)
+		}
+	}
+
+	for {
+		select {
+		case job, ok := <-e.writesQueue:
+			if !ok {
+				return
+			}
+
+			e.write(job)
+
+			// A busy queue must not starve flushes which are due
+			select {
+			case <-tick:
+				flush()
+			default:
+			}
+		case <-tick:
+			flush()
+		}
+	}
+}
+
+// flushWriter flushes the Writer if it is a Flusher
+func (e *engine) flushWriter() error {
+	flusher, ok := e.config.Writer.(Flusher)
+
+	if !ok {
+		return nil
+	}
+
+	err := flusher.Flush()
+
+	if err != nil {
+		logrus.WithField("error", err).Error("flushing writer failed")
+	}
+
+	return err
+}
+
+// closeWriter flushes the Writer, then closes it if it is an io.Closer
+func (e *engine) closeWriter() error {
+	err := e.flushWriter()
+
+	if closer, ok := e.config.Writer.(io.Closer); ok {
+		if closeErr := closer.Close(); closeErr != nil {
+			logrus.WithField("error", closeErr).Error("closing writer failed")
+
+			if err == nil {
+				err = closeErr
+			}
+		}
+	}
+
+	return err
+}
+
+// enqueueWrite hands the job over to the writes stage, blocking while the buffer is full.
+// Time spent blocked is recorded, since it means the Writer is slower than the crawl.
+// It returns `false` if `ctx` is done before the job fits in the buffer, e.g. once the writes stage stopped.
+func (e *engine) enqueueWrite(ctx context.Context, job writeJob) bool {
+	select {
+	case e.writesQueue <- job:
+	default:
+		startTime := time.Now()
+
+		select {
+		case e.writesQueue <- job:
+		case <-ctx.Done():
+			return false
+		}
+
+		stall := time.Since(startTime)
+
+		e.stats.record(func(stats *RunStats) {
+			stats.WriteStalls++
+			stats.WriteStallTime += stall
+		})
+	}
+
+	if buffered := len(e.writesQueue); buffered > 0 {
+		e.stats.record(func(stats *RunStats) {
+			if buffered > stats.WriteBufferPeak {
+				stats.WriteBufferPeak = buffered
+			}
+		})
+	}
+
+	return true
+}
+
+func (e *engine) write(job writeJob) {
+	if job.profile == nil {
+		e.writeEdges(job.edges)
+		return
+	}
+
+	profile := *job.profile
+
+	if err := e.config.Writer.Write(profile); err != nil {
+		e.stats.record(func(stats *RunStats) { stats.WriteFailures++ })
+
+		logrus.WithFields(logrus.Fields{
+			"profile": profile,
+			"error":   err,
+		}).Error("writing profile failed")
+
+		if errors.Is(err, ErrFatalWrite) {
+			e.abort(err)
+		}
+
+		return
+	}
+
+	e.stats.record(func(stats *RunStats) { stats.Written++ })
+}
+
+func (e *engine) writeEdges(edges []Edge) {
+	edgeWriter := e.config.Writer.(EdgeWriter)
+
+	for _, edge := range edges {
+		if err := edgeWriter.WriteEdge(edge); err != nil {
+			e.stats.record(func(stats *RunStats) { stats.WriteFailures++ })
+
+			logrus.WithFields(logrus.Fields{
+				"from":  edge.From,
+				"to":    edge.To,
+				"error": err,
+			}).Error("writing edge failed")
+
+			if errors.Is(err, ErrFatalWrite) {
+				e.abort(err)
+				return
+			}
+
+			continue
+		}
+
+		e.stats.record(func(stats *RunStats) { stats.EdgesWritten++ })
+	}
+}
+
+// visit reports whether the profile wasn't visited before and should be crawled
+func (e *engine) visit(profile Profile) bool {
+	ok, err := e.visited.Visit(visitedKey(profile))
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"profile": profile,
+			"error":   err,
+		}).Error("visiting profile failed")
+		return false
+	}
+
+	if !ok {
+		e.stats.record(func(stats *RunStats) { stats.Duplicates++ })
+		logrus.WithField("profile", profile).Debug("already visited")
+	}
+
+	return ok
+}
+
+// markVisited marks the profile as visited, without counting it as a duplicate if it already was
+func (e *engine) markVisited(profile Profile) {
+	if _, err := e.visited.Visit(visitedKey(profile)); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"profile": profile,
+			"error":   err,
+		}).Error("visiting profile failed")
+	}
+}
@@ -0,0 +1,727 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCrawler(t *testing.T) {
+	_, err := NewCrawler(nil, Config{}, LimiterConfig{})
+	assert.EqualError(t, err, "missing required Source")
+
+	source := &mockSource{}
+
+	_, err = NewCrawler(source, Config{}, LimiterConfig{})
+	assert.EqualError(t, err, "missing required Seed config")
+
+	_, err = NewCrawler(source, Config{Seed: Profile{ID: "1"}}, LimiterConfig{})
+	assert.EqualError(t, err, "missing required Writer config")
+
+	config := Config{
+		Seed:   Profile{ID: "1"},
+		Writer: &mockWriter{},
+	}
+	_, err = NewCrawler(source, config, LimiterConfig{})
+	assert.Equal(t, nil, err)
+
+	config = Config{
+		Seeds:  []Profile{{ID: "1"}, {}},
+		Writer: &mockWriter{},
+	}
+	_, err = NewCrawler(source, config, LimiterConfig{})
+	assert.EqualError(t, err, "invalid Seeds config: seed #1 has neither ID nor Username")
+
+	config.Seeds = config.Seeds[:1]
+	_, err = NewCrawler(source, config, LimiterConfig{})
+	assert.Equal(t, nil, err)
+
+	config.Resume = true
+	_, err = NewCrawler(source, config, LimiterConfig{})
+	assert.EqualError(t, err, "invalid Resume config: missing required Checkpoint config")
+
+	config = Config{Seed: Profile{ID: "1"}, Writer: &mockWriter{}, WriteBuffer: -1}
+	_, err = NewCrawler(source, config, LimiterConfig{})
+	assert.EqualError(t, err, "invalid WriteBuffer config: must not be negative")
+
+	config = Config{Seed: Profile{ID: "1"}, Writer: &mockWriter{}, MaxConcurrency: -1}
+	_, err = NewCrawler(source, config, LimiterConfig{})
+	assert.EqualError(t, err, "invalid MaxConcurrency config: must not be negative")
+}
+
+func TestEngineRunMultipleSeeds(t *testing.T) {
+	source := &mockSource{
+		graph: map[string][]string{
+			"1": {"3"},
+			"2": {"3", "4"},
+		},
+	}
+
+	writer := &mockWriter{}
+	config := Config{
+		Seed:   Profile{ID: "1"},
+		Seeds:  []Profile{{ID: "2"}, {ID: "1"}},
+		Writer: writer,
+	}
+	crawler, _ := NewCrawler(source, config, LimiterConfig{MaxTakes: 10})
+
+	stats, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"1", "2", "3", "4"}, writtenIDs(writer))
+	assert.Equal(t, 1, stats.MaxDepth)
+}
+
+func TestEngineRunUsernameSeed(t *testing.T) {
+	// The seed is suggested back by its ID only
+	source := &mockSource{
+		graph: map[string][]string{
+			"1": {"2"},
+			"2": {"1", "3"},
+		},
+	}
+
+	writer := &mockWriter{}
+	config := Config{
+		Seed:   Profile{Username: "user_1"},
+		Writer: writer,
+	}
+	crawler, _ := NewCrawler(source, config, LimiterConfig{MaxTakes: 10})
+
+	stats, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"1", "2", "3"}, writtenIDs(writer))
+	assert.Equal(t, 1, stats.Duplicates)
+}
+
+func TestEngineRun(t *testing.T) {
+	source := &mockSource{
+		graph: map[string][]string{
+			"1": {"2", "3"},
+			"2": {"1", "3", "4"},
+			"3": {"fail"},
+		},
+	}
+
+	writer := &mockWriter{}
+	config := Config{
+		Seed:   Profile{ID: "1"},
+		Writer: writer,
+	}
+	crawler, _ := NewCrawler(source, config, LimiterConfig{MaxTakes: 10})
+
+	stats, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, []string{"1", "2", "3", "4"}, writtenIDs(writer))
+	assert.Equal(t, 4, stats.Fetched)
+	assert.Equal(t, 4, stats.Written)
+	assert.Equal(t, 1, stats.DetailFailures)
+	assert.Equal(t, 0, stats.RelatedFailures)
+	assert.Equal(t, 0, stats.WriteFailures)
+	assert.Equal(t, 1, stats.Failures())
+	assert.Equal(t, 2, stats.Duplicates)
+	assert.Equal(t, 2, stats.MaxDepth)
+	assert.Greater(t, int64(stats.Duration), int64(0))
+}
+
+func TestEngineRunStrategies(t *testing.T) {
+	graph := map[string][]string{
+		"1":   {"1/1", "1/2"},
+		"1/1": {"1/1/1", "1/1/2"},
+		"1/2": {"1/2/1"},
+	}
+
+	testCases := map[Strategy][]string{
+		BreadthFirst: {"1", "1/1", "1/2", "1/1/1", "1/1/2", "1/2/1"},
+		DepthFirst:   {"1", "1/1", "1/1/1", "1/1/2", "1/2", "1/2/1"},
+	}
+
+	for strategy, expectedIDs := range testCases {
+		writer := &mockWriter{}
+		// A single job at a time, so that profiles are written in the order the frontier pops them
+		config := Config{
+			Seed:           Profile{ID: "1"},
+			Strategy:       strategy,
+			Writer:         writer,
+			MaxConcurrency: 1,
+		}
+		crawler, _ := NewCrawler(&mockSource{graph: graph}, config, LimiterConfig{MaxTakes: 10})
+
+		_, err := crawler.Run(context.Background())
+		assert.Equal(t, nil, err)
+
+		profileIDs := []string{}
+
+		for _, profile := range writer.WrittenProfiles {
+			profileIDs = append(profileIDs, profile.ID)
+		}
+
+		assert.Equal(t, expectedIDs, profileIDs, strategy.String())
+	}
+}
+
+func TestEngineRunMaxDepth(t *testing.T) {
+	writer := &mockWriter{}
+	config := Config{
+		MaxDepth: 2,
+		Seed:     Profile{ID: "1"},
+		Writer:   writer,
+	}
+	crawler, _ := NewCrawler(&dummySession{}, config, LimiterConfig{MaxTakes: 100})
+
+	stats, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+
+	// 1 seed, 5 profiles at depth 1, 25 profiles at depth 2
+	assert.Equal(t, 31, len(writer.WrittenProfiles))
+	assert.Equal(t, 2, stats.MaxDepth)
+
+	for _, profile := range writer.WrittenProfiles {
+		assert.Equal(t, strings.Count(profile.ID, "/"), profile.Depth)
+	}
+}
+
+func TestEngineRunFetchErrors(t *testing.T) {
+	rateLimited := NewFetchError("fetch profile", Profile{}, "", 429, nil)
+	source := &mockSource{
+		graph: map[string][]string{
+			"1": {"gone", "limited", "always_limited", "2"},
+		},
+		failures: map[string][]error{
+			"gone":           {NewFetchError("fetch profile", Profile{}, "", 404, nil)},
+			"limited":        {rateLimited, rateLimited},
+			"always_limited": {rateLimited, rateLimited, rateLimited, rateLimited},
+		},
+	}
+
+	writer := &mockWriter{}
+	config := Config{
+		Seed:   Profile{ID: "1"},
+		Writer: writer,
+	}
+	crawler, _ := NewCrawler(source, config, LimiterConfig{MaxTakes: 10})
+
+	stats, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"1", "2", "limited"}, writtenIDs(writer))
+	assert.Equal(t, 1, stats.NotFound)
+	assert.Equal(t, 2+maxRequeues, stats.Requeued)
+	assert.Equal(t, 1, stats.DetailFailures)
+}
+
+func TestEngineRunUnauthorized(t *testing.T) {
+	unauthorized := NewFetchError("fetch profile", Profile{}, "", 401, nil)
+	source := &mockSource{
+		graph: map[string][]string{
+			"1": {"2", "3", "4", "5"},
+			"2": {"2/1", "2/2"},
+		},
+		failures: map[string][]error{
+			"3": {unauthorized},
+		},
+	}
+
+	writer := &mockWriter{}
+	config := Config{
+		Seed:   Profile{ID: "1"},
+		Writer: writer,
+	}
+	limiterConfig := LimiterConfig{
+		DeferTime: 10 * time.Millisecond,
+		MaxTakes:  100,
+	}
+	crawler, _ := NewCrawler(source, config, limiterConfig)
+
+	stats, err := crawler.Run(context.Background())
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+	assert.EqualError(t, err, "crawl aborted: fetch profile error: status 401")
+	assert.Equal(t, []string{"1", "2"}, writtenIDs(writer))
+	assert.Equal(t, 1, stats.DetailFailures)
+
+	// The unauthorized profile and the rest of the frontier can be resumed
+	pendingIDs := []string{}
+
+	for _, profile := range stats.Pending {
+		pendingIDs = append(pendingIDs, profile.ID)
+	}
+
+	assert.Equal(t, []string{"3", "4", "5", "2/1", "2/2"}, pendingIDs)
+}
+
+func TestEngineRunResume(t *testing.T) {
+	source := &mockSource{
+		graph: map[string][]string{
+			"1": {"2", "3"},
+			"2": {"4"},
+			"3": {"5"},
+		},
+		failures: map[string][]error{
+			"3": {NewFetchError("fetch profile", Profile{}, "", 401, nil)},
+		},
+	}
+
+	checkpoint, err := NewFileCheckpoint(filepath.Join(t.TempDir(), "checkpoint.jsonl"))
+	assert.Equal(t, nil, err)
+	defer checkpoint.Close()
+
+	writer := &mockWriter{}
+	config := Config{
+		Checkpoint: checkpoint,
+		Seed:       Profile{ID: "1"},
+		Writer:     writer,
+	}
+	limiterConfig := LimiterConfig{
+		DeferTime: 10 * time.Millisecond,
+		MaxTakes:  100,
+	}
+	crawler, _ := NewCrawler(source, config, limiterConfig)
+
+	_, err = crawler.Run(context.Background())
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+	assert.Equal(t, []string{"1", "2"}, writtenIDs(writer))
+
+	// Resuming crawls the rest of the graph only, keeping the depth of pending profiles
+	writer = &mockWriter{}
+	config.Resume = true
+	config.Writer = writer
+	crawler, _ = NewCrawler(source, config, limiterConfig)
+
+	stats, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"3", "4", "5"}, writtenIDs(writer))
+	assert.Equal(t, 2, stats.MaxDepth)
+
+	state, err := checkpoint.Load()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(state.Pending))
+	assert.Equal(t, 5, len(state.Completed))
+
+	// A new run without resuming starts over from the seed
+	writer = &mockWriter{}
+	config.Resume = false
+	config.Writer = writer
+	crawler, _ = NewCrawler(source, config, limiterConfig)
+
+	_, err = crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, writtenIDs(writer))
+}
+
+func TestEngineRunEdges(t *testing.T) {
+	source := &mockSource{
+		graph: map[string][]string{
+			"1": {"2", "3"},
+			"2": {"1", "-1"},
+		},
+	}
+
+	writer := &mockEdgeWriter{}
+	config := Config{
+		Seed:   Profile{ID: "1"},
+		Writer: writer,
+	}
+	crawler, _ := NewCrawler(source, config, LimiterConfig{MaxTakes: 10})
+
+	stats, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+
+	// Edges to visited profiles are kept, edges failing to be written are counted
+	edges := []string{}
+
+	for _, edge := range writer.WrittenEdges {
+		edges = append(edges, edge.From.ID+"->"+edge.To.ID)
+
+		assert.Equal(t, edge.From.Depth, edge.Depth)
+		assert.False(t, edge.Timestamp.IsZero())
+	}
+
+	sort.Strings(edges)
+	assert.Equal(t, []string{"1->2", "1->3", "2->1"}, edges)
+	assert.Equal(t, 1, writer.WrittenEdges[len(writer.WrittenEdges)-1].Depth)
+	assert.Equal(t, 3, stats.EdgesWritten)
+	assert.Equal(t, 2, stats.WriteFailures)
+}
+
+func TestEngineRunWriteFailures(t *testing.T) {
+	source := &mockSource{
+		graph: map[string][]string{"1": {"-1"}},
+	}
+
+	config := Config{
+		Seed:   Profile{ID: "1"},
+		Writer: &mockWriter{},
+	}
+	crawler, _ := NewCrawler(source, config, LimiterConfig{MaxTakes: 10})
+
+	stats, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, stats.Fetched)
+	assert.Equal(t, 1, stats.Written)
+	assert.Equal(t, 1, stats.WriteFailures)
+}
+
+func TestEngineRunFatalWrite(t *testing.T) {
+	source := &mockSource{
+		graph: map[string][]string{"1": {"2"}, "2": {"3"}},
+	}
+
+	config := Config{
+		Seed:   Profile{ID: "1"},
+		Writer: fatalWriter{},
+	}
+	crawler, _ := NewCrawler(source, config, LimiterConfig{MaxTakes: 10})
+
+	stats, err := crawler.Run(context.Background())
+	assert.True(t, errors.Is(err, ErrFatalWrite))
+	assert.Equal(t, 0, stats.Written)
+	assert.Less(t, stats.Fetched, 3)
+}
+
+func TestEngineRunWriteBuffer(t *testing.T) {
+	source := &mockSource{
+		graph: map[string][]string{"1": {"2", "3", "4", "5", "6", "7", "8", "9"}},
+	}
+
+	// A slow writer stalls crawl jobs when the writes stage is unbuffered
+	writer := &slowWriter{delay: 10 * time.Millisecond}
+	config := Config{Seed: Profile{ID: "1"}, Writer: writer}
+	crawler, _ := NewCrawler(source, config, LimiterConfig{MaxTakes: 9, MaxWorkers: 9})
+
+	stats, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 9, stats.Written)
+	assert.Greater(t, stats.WriteStalls, 0)
+	assert.Greater(t, stats.WriteStallTime, time.Duration(0))
+
+	// Buffered writes are decoupled from crawling, and drained before the run ends
+	writer = &slowWriter{delay: 10 * time.Millisecond}
+	config = Config{Seed: Profile{ID: "1"}, Writer: writer, WriteBuffer: 20}
+	crawler, _ = NewCrawler(source, config, LimiterConfig{MaxTakes: 9, MaxWorkers: 9})
+
+	stats, err = crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 9, stats.Written)
+	assert.Equal(t, 9, len(writer.WrittenProfiles))
+	assert.Equal(t, 0, stats.WriteStalls)
+	assert.Greater(t, stats.WriteBufferPeak, 1)
+	assert.LessOrEqual(t, stats.WriteBufferPeak, 20)
+}
+
+func TestEngineRunExactMaxTakes(t *testing.T) {
+	fakeErr := errors.New("fake error")
+	source := &mockSource{
+		graph:    map[string][]string{"1": {"2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12"}},
+		failures: map[string][]error{"2": {fakeErr}, "3": {fakeErr}, "4": {fakeErr}},
+	}
+
+	// All related profiles are taken at once, but failed fetches give their slot back
+	writer := &mockWriter{}
+	config := Config{Seed: Profile{ID: "1"}, Writer: writer}
+	crawler, _ := NewCrawler(source, config, LimiterConfig{MaxTakes: 5, MaxWorkers: 11})
+
+	stats, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 5, stats.Fetched)
+	assert.Equal(t, 5, stats.Written)
+	assert.Equal(t, 5, len(writer.WrittenProfiles))
+	assert.Equal(t, 3, stats.DetailFailures)
+	assert.Equal(t, 4, len(stats.Pending))
+}
+
+func TestEngineRunMaxConcurrency(t *testing.T) {
+	graph := map[string][]string{"1": {"2", "3", "4", "5", "6", "7", "8", "9"}}
+
+	// Every tick allows 8 profiles, which are all crawled at once without a concurrency limit
+	source := &slowSource{mockSource: mockSource{graph: graph}, delay: 20 * time.Millisecond}
+	config := Config{Seed: Profile{ID: "1"}, Writer: &mockWriter{}}
+	crawler, _ := NewCrawler(source, config, LimiterConfig{MaxTakes: 9, MaxWorkers: 9})
+
+	stats, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 9, stats.Written)
+	assert.Greater(t, source.peak, 2)
+
+	// The concurrency limit holds whatever the limiter allows, token buckets included
+	for _, limiterConfig := range []LimiterConfig{{MaxTakes: 9, MaxWorkers: 9}, {Rate: 1000, Burst: 9, MaxTakes: 9}} {
+		source = &slowSource{mockSource: mockSource{graph: graph}, delay: 20 * time.Millisecond}
+		config = Config{Seed: Profile{ID: "1"}, Writer: &mockWriter{}, MaxConcurrency: 2}
+		crawler, _ = NewCrawler(source, config, limiterConfig)
+
+		stats, err = crawler.Run(context.Background())
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 9, stats.Written)
+		assert.Equal(t, 2, source.peak)
+	}
+}
+
+func TestEngineRunWriterLifecycle(t *testing.T) {
+	source := &mockSource{
+		graph: map[string][]string{"1": {"2", "3"}},
+	}
+
+	// Flushed on every interval, then flushed and closed once the run ends
+	writer := &lifecycleWriter{writeDelay: 20 * time.Millisecond}
+	config := Config{Seed: Profile{ID: "1"}, Writer: writer, FlushInterval: 5 * time.Millisecond}
+	crawler, _ := NewCrawler(source, config, LimiterConfig{MaxTakes: 3, MaxWorkers: 3})
+
+	stats, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, stats.Written)
+	assert.Greater(t, writer.flushes, 1)
+	assert.Equal(t, 1, writer.closes)
+
+	// Closing errors are reported by the run
+	writer = &lifecycleWriter{closeErr: errors.New("disk full")}
+	config = Config{Seed: Profile{ID: "1"}, Writer: writer}
+	crawler, _ = NewCrawler(source, config, LimiterConfig{MaxTakes: 3})
+
+	_, err = crawler.Run(context.Background())
+	assert.EqualError(t, err, "closing writer failed: disk full")
+	assert.Equal(t, 1, writer.flushes)
+}
+
+func TestEngineRunOnce(t *testing.T) {
+	writer := &lifecycleWriter{}
+	config := Config{Seed: Profile{ID: "1"}, Writer: writer}
+	crawler, _ := NewCrawler(&mockSource{}, config, LimiterConfig{MaxTakes: 10})
+
+	_, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+
+	// The writer is closed by the first run, it is never written again
+	_, err = crawler.Run(context.Background())
+	assert.Equal(t, ErrAlreadyRun, err)
+	assert.Equal(t, 1, len(writer.WrittenProfiles))
+	assert.Equal(t, 1, writer.closes)
+}
+
+func TestEngineRunWriterClosedOnCancellation(t *testing.T) {
+	writer := &lifecycleWriter{}
+	config := Config{Seed: Profile{ID: "1"}, Writer: writer}
+	crawler, _ := NewCrawler(&dummySession{}, config, LimiterConfig{DeferTime: 10 * time.Millisecond, MaxTakes: 1000})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := crawler.Run(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Greater(t, len(writer.WrittenProfiles), 0)
+	assert.Equal(t, 1, writer.flushes)
+	assert.Equal(t, 1, writer.closes)
+}
+
+func TestEngineRunWriterClosedOnPanic(t *testing.T) {
+	// Panics of crawl jobs abort the run
+	source := &mockSource{
+		graph: map[string][]string{"1": {"panic"}},
+	}
+
+	writer := &lifecycleWriter{}
+	config := Config{Seed: Profile{ID: "1"}, Writer: writer}
+	crawler, _ := NewCrawler(source, config, LimiterConfig{MaxTakes: 10})
+
+	stats, err := crawler.Run(context.Background())
+	assert.EqualError(t, err, "crawl aborted: crawl job panicked: fake panic")
+	assert.Equal(t, []Profile{{ID: "panic", Depth: 1}}, stats.Pending)
+	assert.Equal(t, 1, writer.closes)
+
+	// Panics of the writer are propagated, once the writer is closed
+	initialGoRoutines := runtime.NumGoroutine()
+	source = &mockSource{
+		graph: map[string][]string{"1": {"2", "3", "4", "5"}},
+	}
+
+	writer = &lifecycleWriter{writeDelay: 20 * time.Millisecond, writePanics: true}
+	config = Config{Seed: Profile{ID: "1"}, Writer: writer}
+	crawler, _ = NewCrawler(source, config, LimiterConfig{MaxTakes: 10})
+
+	assert.Panics(t, func() { crawler.Run(context.Background()) })
+	assert.Equal(t, 1, writer.closes)
+
+	// Jobs blocked on the full writes queue are released by the cancellation of the panicked run
+	assertNoLeakedGoroutines(t, initialGoRoutines)
+}
+
+func TestEngineRunCancellation(t *testing.T) {
+	initialGoRoutines := runtime.NumGoroutine()
+
+	writer := &mockWriter{}
+	config := Config{
+		Seed:   Profile{ID: "1"},
+		Writer: writer,
+	}
+	limiterConfig := LimiterConfig{
+		DeferTime: 10 * time.Millisecond,
+		MaxTakes:  1000,
+	}
+	crawler, _ := NewCrawler(&dummySession{}, config, limiterConfig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	stats, err := crawler.Run(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, 0, stats.Failures())
+	assert.Greater(t, len(stats.Pending), 0)
+	assert.Greater(t, len(writer.WrittenProfiles), 0)
+	assert.Less(t, len(writer.WrittenProfiles), 1000)
+
+	time.Sleep(limiterConfig.DeferTime)
+	assert.Equal(t, initialGoRoutines, runtime.NumGoroutine())
+}
+
+/* Private stuffs */
+
+// mockSource serves profiles from an adjacency list, resolves username `user_<id>` of profiles without ID,
+// fails fetching profile `fail`, and returns `failures` one by one on successive fetches of the other profiles
+type mockSource struct {
+	graph    map[string][]string
+	mu       sync.Mutex
+	failures map[string][]error
+}
+
+func (m *mockSource) FetchProfileDetail(_ context.Context, profile Profile) (Profile, error) {
+	switch profile.ID {
+	case "fail":
+		return Profile{}, errors.New("fake error")
+	case "panic":
+		panic("fake panic")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if failures := m.failures[profile.ID]; len(failures) > 0 {
+		m.failures[profile.ID] = failures[1:]
+		return Profile{}, failures[0]
+	}
+
+	id := profile.ID
+
+	if id == "" {
+		id = strings.TrimPrefix(profile.Username, "user_")
+	}
+
+	return Profile{ID: id, Username: "user_" + id}, nil
+}
+
+func (m *mockSource) FetchRelatedProfiles(_ context.Context, fromProfile Profile) ([]Profile, error) {
+	profiles := []Profile{}
+
+	for _, id := range m.graph[fromProfile.ID] {
+		profiles = append(profiles, Profile{ID: id})
+	}
+
+	return profiles, nil
+}
+
+// mockEdgeWriter also records edges, and fails writing edges to profile `-1`
+type mockEdgeWriter struct {
+	mockWriter
+	WrittenEdges []Edge
+}
+
+func (m *mockEdgeWriter) WriteEdge(edge Edge) error {
+	if edge.To.ID == "-1" {
+		return errors.New("error writing edge to output stream")
+	}
+
+	m.WrittenEdges = append(m.WrittenEdges, edge)
+	return nil
+}
+
+// slowSource takes `delay` to fetch every profile, and records the most fetches in flight at once
+type slowSource struct {
+	mockSource
+	delay time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+}
+
+func (s *slowSource) FetchProfileDetail(ctx context.Context, profile Profile) (Profile, error) {
+	s.mu.Lock()
+	s.inFlight++
+
+	if s.inFlight > s.peak {
+		s.peak = s.inFlight
+	}
+
+	s.mu.Unlock()
+
+	time.Sleep(s.delay)
+
+	s.mu.Lock()
+	s.inFlight--
+	s.mu.Unlock()
+
+	return s.mockSource.FetchProfileDetail(ctx, profile)
+}
+
+// slowWriter takes `delay` to write every profile
+type slowWriter struct {
+	mockWriter
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(profile Profile) error {
+	time.Sleep(w.delay)
+	return w.mockWriter.Write(profile)
+}
+
+// lifecycleWriter counts flushes and closes
+type lifecycleWriter struct {
+	mockWriter
+	writeDelay  time.Duration
+	writePanics bool
+	closeErr    error
+	flushes     int
+	closes      int
+}
+
+func (w *lifecycleWriter) Write(profile Profile) error {
+	time.Sleep(w.writeDelay)
+
+	if w.writePanics {
+		panic("fake writer panic")
+	}
+
+	return w.mockWriter.Write(profile)
+}
+
+func (w *lifecycleWriter) Flush() error {
+	w.flushes++
+	return nil
+}
+
+func (w *lifecycleWriter) Close() error {
+	w.closes++
+	return w.closeErr
+}
+
+type fatalWriter struct{}
+
+func (fatalWriter) Write(Profile) error {
+	return fmt.Errorf("%w: disk full", ErrFatalWrite)
+}
+
+func writtenIDs(writer *mockWriter) []string {
+	profileIDs := []string{}
+
+	for _, profile := range writer.WrittenProfiles {
+		profileIDs = append(profileIDs, profile.ID)
+	}
+
+	sort.Strings(profileIDs)
+	return profileIDs
+}
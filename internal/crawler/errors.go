@@ -0,0 +1,97 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+)
+
+// Sentinel errors matched by FetchError with `errors.Is`, according to its status code
+var (
+	// ErrNotFound: the profile doesn't exist anymore, e.g. a deleted account
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized: the session is missing, expired or has to pass a checkpoint
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrRateLimited: the source throttles requests, even after retries
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// ErrFatalWrite is matched by Writer errors which can't be recovered from, the run is aborted when it is returned
+var ErrFatalWrite = errors.New("fatal write error")
+
+// ErrAlreadyRun is returned when a Crawler is run again, it serves a single run since its Writer is closed at the end
+var ErrAlreadyRun = errors.New("crawler already run, create a new one for every run")
+
+// FetchError describes a request to a source which didn't succeed
+// @param Op: the failed operation, e.g. `fetch profile`
+// @param Profile: the profile being fetched
+// @param URL: the requested URL
+// @param StatusCode: the HTTP status code of the response
+// @param Body: the beginning of the response body, to help debugging
+// @param Err: the cause detected by the source besides the status code, e.g. a login redirect
+type FetchError struct {
+	Op         string
+	Profile    Profile
+	URL        string
+	StatusCode int
+	Body       string
+	Err        error
+}
+
+func (e *FetchError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s error: status %d: %v", e.Op, e.StatusCode, e.Err)
+	}
+
+	return fmt.Sprintf("%s error: status %d", e.Op, e.StatusCode)
+}
+
+// Unwrap returns the cause detected by the source, if any
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches ErrNotFound, ErrUnauthorized and ErrRateLimited by the status code
+func (e *FetchError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	default:
+		return false
+	}
+}
+
+// NewFetchError creates a FetchError, keeping only a snippet of `body`
+func NewFetchError(op string, profile Profile, url string, statusCode int, body []byte) *FetchError {
+	return &FetchError{
+		Op:         op,
+		Profile:    profile,
+		URL:        url,
+		StatusCode: statusCode,
+		Body:       snippet(body, maxBodySnippet),
+	}
+}
+
+/* Private stuffs */
+
+const maxBodySnippet = 256
+
+// snippet truncates `body` to at most `limit` bytes, without breaking UTF-8 characters
+func snippet(body []byte, limit int) string {
+	if len(body) <= limit {
+		return string(body)
+	}
+
+	body = body[:limit]
+
+	for len(body) > 0 && !utf8.Valid(body) {
+		body = body[:len(body)-1]
+	}
+
+	return string(body) + "…"
+}
@@ -0,0 +1,65 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchError(t *testing.T) {
+	err := NewFetchError("fetch profile", fakeProfile, "https://fake-url", 404, []byte("Not Found"))
+
+	assert.EqualError(t, err, "fetch profile error: status 404")
+	assert.Equal(t, "https://fake-url", err.URL)
+	assert.Equal(t, "Not Found", err.Body)
+	assert.Equal(t, fakeProfile, err.Profile)
+
+	wrappedErr := fmt.Errorf("crawling failed: %w", err)
+	assert.True(t, errors.Is(wrappedErr, ErrNotFound))
+	assert.False(t, errors.Is(wrappedErr, ErrUnauthorized))
+	assert.False(t, errors.Is(wrappedErr, ErrRateLimited))
+
+	fetchErr := &FetchError{}
+	assert.True(t, errors.As(wrappedErr, &fetchErr))
+	assert.Equal(t, 404, fetchErr.StatusCode)
+}
+
+func TestFetchErrorStatuses(t *testing.T) {
+	testCases := map[int]error{
+		401: ErrUnauthorized,
+		403: ErrUnauthorized,
+		404: ErrNotFound,
+		410: ErrNotFound,
+		429: ErrRateLimited,
+	}
+
+	for statusCode, expectedErr := range testCases {
+		err := NewFetchError("fetch profile", Profile{}, "", statusCode, nil)
+		assert.True(t, errors.Is(err, expectedErr), statusCode)
+	}
+
+	err := NewFetchError("fetch profile", Profile{}, "", 500, nil)
+
+	for _, sentinel := range []error{ErrNotFound, ErrUnauthorized, ErrRateLimited} {
+		assert.False(t, errors.Is(err, sentinel))
+	}
+}
+
+func TestFetchErrorBodySnippet(t *testing.T) {
+	body := []byte(strings.Repeat("a", maxBodySnippet-1) + "ê")
+	err := NewFetchError("fetch profile", Profile{}, "", 500, body)
+
+	assert.Equal(t, strings.Repeat("a", maxBodySnippet-1)+"…", err.Body)
+}
+
+func TestFetchErrorCause(t *testing.T) {
+	err := NewFetchError("fetch profile", Profile{}, "", 302, nil)
+	err.Err = fmt.Errorf("%w: redirected to /accounts/login/", ErrUnauthorized)
+
+	assert.EqualError(t, err, "fetch profile error: status 302: unauthorized: redirected to /accounts/login/")
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+	assert.False(t, errors.Is(err, ErrNotFound))
+}
@@ -0,0 +1,150 @@
+package crawler
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Strategy decides the order in which discovered profiles are crawled
+type Strategy int
+
+const (
+	// BreadthFirst crawls all profiles of a depth before going deeper
+	BreadthFirst Strategy = iota
+	// DepthFirst follows the first suggestion of every profile as deep as possible
+	DepthFirst
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case BreadthFirst:
+		return "bfs"
+	case DepthFirst:
+		return "dfs"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseStrategy converts `bfs` or `dfs` into a Strategy
+func ParseStrategy(value string) (Strategy, error) {
+	for _, strategy := range []Strategy{BreadthFirst, DepthFirst} {
+		if value == strategy.String() {
+			return strategy, nil
+		}
+	}
+
+	return BreadthFirst, fmt.Errorf("unknown strategy %q, expected bfs or dfs", value)
+}
+
+/* Private stuffs */
+
+// frontier holds profiles discovered but not crawled yet, in the order decided by a Strategy.
+// It also counts in-flight profiles, so that waiters know when the crawl graph is exhausted.
+type frontier struct {
+	strategy Strategy
+
+	// mu: guards all fields below
+	// cond: signals changes of profiles, inFlight and closed
+	mu       sync.Mutex
+	cond     *sync.Cond
+	profiles []Profile
+	inFlight int
+	closed   bool
+}
+
+func newFrontier(strategy Strategy) *frontier {
+	f := &frontier{strategy: strategy}
+	f.cond = sync.NewCond(&f.mu)
+
+	return f
+}
+
+// push appends profiles to the frontier, keeping their relative order for both strategies
+func (f *frontier) push(profiles ...Profile) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.strategy == DepthFirst {
+		for idx := len(profiles) - 1; idx >= 0; idx-- {
+			f.profiles = append(f.profiles, profiles[idx])
+		}
+	} else {
+		f.profiles = append(f.profiles, profiles...)
+	}
+
+	f.cond.Broadcast()
+}
+
+// wait blocks until a profile is available,
+// or returns `false` if the frontier is closed or exhausted with no in-flight profiles left
+func (f *frontier) wait() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for !f.closed && len(f.profiles) == 0 && f.inFlight > 0 {
+		f.cond.Wait()
+	}
+
+	return !f.closed && len(f.profiles) > 0
+}
+
+// pop removes the next profile from the frontier and marks it in-flight
+func (f *frontier) pop() (Profile, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed || len(f.profiles) == 0 {
+		return Profile{}, false
+	}
+
+	var profile Profile
+
+	if f.strategy == DepthFirst {
+		profile = f.profiles[len(f.profiles)-1]
+		f.profiles = f.profiles[:len(f.profiles)-1]
+	} else {
+		profile = f.profiles[0]
+		f.profiles = f.profiles[1:]
+	}
+
+	f.inFlight++
+	return profile, true
+}
+
+// done marks an in-flight profile as finished
+func (f *frontier) done() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.inFlight--
+	f.cond.Broadcast()
+}
+
+// close stops the frontier from handing out profiles and wakes up all waiters
+func (f *frontier) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	f.cond.Broadcast()
+}
+
+// drain removes and returns all profiles left in the frontier, in crawl order
+func (f *frontier) drain() []Profile {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	profiles := make([]Profile, 0, len(f.profiles))
+
+	if f.strategy == DepthFirst {
+		for idx := len(f.profiles) - 1; idx >= 0; idx-- {
+			profiles = append(profiles, f.profiles[idx])
+		}
+	} else {
+		profiles = append(profiles, f.profiles...)
+	}
+
+	f.profiles = nil
+	return profiles
+}
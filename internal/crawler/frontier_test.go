@@ -0,0 +1,121 @@
+package crawler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrategy(t *testing.T) {
+	assert.Equal(t, "bfs", BreadthFirst.String())
+	assert.Equal(t, "dfs", DepthFirst.String())
+	assert.Equal(t, "unknown", Strategy(-1).String())
+
+	strategy, err := ParseStrategy("dfs")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, DepthFirst, strategy)
+
+	_, err = ParseStrategy("random")
+	assert.EqualError(t, err, `unknown strategy "random", expected bfs or dfs`)
+}
+
+func TestFrontierBreadthFirst(t *testing.T) {
+	f := newFrontier(BreadthFirst)
+	f.push(Profile{ID: "1"}, Profile{ID: "2"})
+	f.push(Profile{ID: "3"})
+
+	assert.Equal(t, []string{"1", "2", "3"}, popIDs(f))
+}
+
+func TestFrontierDepthFirst(t *testing.T) {
+	f := newFrontier(DepthFirst)
+	f.push(Profile{ID: "1"}, Profile{ID: "2"})
+
+	profile, _ := f.pop()
+	assert.Equal(t, "1", profile.ID)
+
+	f.push(Profile{ID: "1/1"}, Profile{ID: "1/2"})
+
+	assert.Equal(t, []string{"1/1", "1/2", "2"}, popIDs(f))
+}
+
+func TestFrontierWait(t *testing.T) {
+	f := newFrontier(BreadthFirst)
+
+	// Empty frontier without in-flight profiles is exhausted
+	assert.False(t, f.wait())
+
+	f.push(Profile{ID: "1"})
+	assert.True(t, f.wait())
+
+	_, _ = f.pop()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		f.push(Profile{ID: "1/1"})
+		f.done()
+	}()
+
+	// Blocks until the in-flight profile pushes its related profiles
+	assert.True(t, f.wait())
+
+	_, _ = f.pop()
+	f.done()
+	assert.False(t, f.wait())
+}
+
+func TestFrontierClose(t *testing.T) {
+	f := newFrontier(BreadthFirst)
+	f.push(Profile{ID: "1"})
+	_, _ = f.pop()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		f.close()
+	}()
+
+	// Blocks on the in-flight profile until closed
+	assert.False(t, f.wait())
+
+	f.push(Profile{ID: "1/1"})
+	_, ok := f.pop()
+	assert.False(t, ok)
+}
+
+func TestFrontierDrain(t *testing.T) {
+	for strategy, expectedIDs := range map[Strategy][]string{
+		BreadthFirst: {"1", "2", "3"},
+		DepthFirst:   {"1", "2", "3"},
+	} {
+		f := newFrontier(strategy)
+		f.push(Profile{ID: "1"}, Profile{ID: "2"}, Profile{ID: "3"})
+		f.close()
+
+		profiles := f.drain()
+		profileIDs := []string{}
+
+		for _, profile := range profiles {
+			profileIDs = append(profileIDs, profile.ID)
+		}
+
+		assert.Equal(t, expectedIDs, profileIDs, strategy.String())
+		assert.Equal(t, []Profile{}, f.drain())
+	}
+}
+
+/* Private stuffs */
+
+func popIDs(f *frontier) []string {
+	profileIDs := []string{}
+
+	for {
+		profile, ok := f.pop()
+
+		if !ok {
+			return profileIDs
+		}
+
+		profileIDs = append(profileIDs, profile.ID)
+	}
+}
@@ -1,152 +1,173 @@
 package crawler
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"net/http"
-	"sync"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-resty/resty/v2"
-	"github.com/sirupsen/logrus"
 )
 
-// NewInstagramCrawler initializes a crawler for instagram.com
+// NewInstagramCrawler initializes a crawler for instagram.com.
+// Seeds must have a username, since profiles are fetched by username.
 func NewInstagramCrawler(config Config, limiterConfig LimiterConfig) (Crawler, error) {
-	httpClient := config.Client
-
-	if httpClient == nil {
-		httpClient = &http.Client{}
+	for idx, seed := range config.seeds() {
+		if seed.Username == "" {
+			return nil, fmt.Errorf("invalid Seeds config: seed #%d has no Username, required by instagram", idx)
+		}
 	}
 
-	client := resty.NewWithClient(httpClient)
+	return NewCrawler(newInstagramSession(config), config, limiterConfig)
+}
 
-	if config.Seed.Username == "" && config.Seed.ID == "" {
-		return nil, errors.New("missing required Seed config")
-	}
+// OnFeedback reports every attempt of the session requests to `receive`, including retried ones and
+// transport failures. It must be called before the first request.
+func (s *instagramSession) OnFeedback(receive func(Feedback)) {
+	httpClient := s.client.GetClient()
+	httpClient.Transport = &feedbackTransport{base: httpClient.Transport, receive: receive}
+}
 
-	if config.Writer == nil {
-		return nil, errors.New("missing required Writer config")
+// Stats reports metrics collected from all requests made by the session
+func (s *instagramSession) Stats() SourceStats {
+	return SourceStats{
+		BytesDownloaded: atomic.LoadInt64(&s.bytesDownloaded),
+		Retries:         atomic.LoadInt64(&s.retries),
 	}
-
-	return &instagramSession{
-		client:        client,
-		config:        config,
-		limiterConfig: limiterConfig,
-	}, nil
 }
 
-func (s *instagramSession) Run() {
-	s.limiter = NewLimiter(s.limiterConfig)
-	s.profilesQueue = make(chan Profile)
+/* Private stuffs */
 
-	go func() {
-		s.jobsWg = &sync.WaitGroup{}
+func newInstagramSession(config Config) *instagramSession {
+	// The client is copied, since the session changes its transport
+	httpClient := &http.Client{}
 
-		s.jobsWg.Add(1)
-		go s.crawl(s.config.Seed)
+	if config.Client != nil {
+		clientCopy := *config.Client
+		httpClient = &clientCopy
+	}
 
-		s.jobsWg.Wait()
-		s.limiter.Wait()
+	credentials := config.Credentials
 
-		close(s.profilesQueue)
-	}()
+	if credentials == nil && config.SessionID != "" {
+		credentials = StaticCredentials(config.SessionID)
+	}
 
-	for profile := range s.profilesQueue {
-		_ = s.config.Writer.Write(profile)
+	session := &instagramSession{
+		client:      resty.NewWithClient(httpClient),
+		credentials: credentials,
 	}
-}
 
-/* Private stuffs */
+	session.client.SetRedirectPolicy(resty.RedirectPolicyFunc(stopAtLogin))
+	session.client.OnAfterResponse(session.countBytes)
+	applyRetryPolicy(session.client, config.Retry, session.countRetry)
 
-var _ Crawler = (*instagramSession)(nil)
+	return session
+}
 
-type instagramSession struct {
-	// Received configurations
-	config        Config
-	limiterConfig LimiterConfig
+var (
+	_ Source           = (*instagramSession)(nil)
+	_ StatsReporter    = (*instagramSession)(nil)
+	_ FeedbackReporter = (*instagramSession)(nil)
+)
 
+type instagramSession struct {
 	// client: HTTP client
-	// jobsWg: wait group for crawl jobs
-	client        *resty.Client
-	jobsWg        *sync.WaitGroup
-	limiter       Limiter
-	profilesQueue chan Profile
+	// credentials: session ID provider, requests are anonymous if `nil`
+	// bytesDownloaded: atomic counter of received response bodies
+	// retries: atomic counter of retried requests
+	client          *resty.Client
+	credentials     CredentialsProvider
+	bytesDownloaded int64
+	retries         int64
 }
 
 func (s *instagramSession) baseURL() string {
 	return "https://www.instagram.com"
 }
 
-func (s *instagramSession) cookie() *http.Cookie {
+func (s *instagramSession) cookie() (*http.Cookie, error) {
+	sessionID := Secret("")
+
+	if s.credentials != nil {
+		var err error
+		sessionID, err = s.credentials.SessionID()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &http.Cookie{
 		Domain: ".instagram.com",
 		Path:   "/",
 		Name:   "sessionid",
-		Value:  s.config.SessionID,
-	}
+		Value:  sessionID.Reveal(),
+	}, nil
 }
 
-func (s *instagramSession) suggestedQueryHash() string {
-	// The query param to fetch suggested profiles
-	return "d4d88dc1500312af6f937f7b804c68c3"
+func (s *instagramSession) countBytes(_ *resty.Client, resp *resty.Response) error {
+	atomic.AddInt64(&s.bytesDownloaded, resp.Size())
+	return nil
 }
 
-func (s *instagramSession) userAgent() string {
-	return "Mozilla/5.0 (X11; Linux x86_64; rv:88.0) Gecko/20100101 Firefox/88.0"
+func (s *instagramSession) countRetry() {
+	atomic.AddInt64(&s.retries, 1)
 }
 
-func (s *instagramSession) crawl(profile Profile) {
-	defer s.jobsWg.Done()
-
-	ok := s.limiter.Take()
+// fetchError describes a failed response, classifying login redirects and checkpoints as ErrUnauthorized
+func (s *instagramSession) fetchError(op string, profile Profile, resp *resty.Response) error {
+	err := NewFetchError(op, profile, resp.Request.URL, resp.StatusCode(), resp.Body())
 
-	if !ok {
-		logrus.WithField("profile", profile).Info("max takes reached")
-		return
+	if location := resp.Header().Get("Location"); isLoginURL(location) {
+		err.Err = fmt.Errorf("%w: redirected to %s", ErrUnauthorized, location)
+		return err
 	}
 
-	logrus.WithFields(logrus.Fields{
-		"profile": profile,
-		"time":    time.Now().Format("15:04:05.000"),
-	}).Info("crawling")
-
-	profileDetail, err := s.fetchProfileDetail(profile)
-
-	if err != nil {
-		logrus.WithField("profile", profile).Error("fetchProfileDetail failed")
-		return
+	for _, marker := range instagramAuthMarkers {
+		if bytes.Contains(resp.Body(), []byte(marker)) {
+			err.Err = fmt.Errorf("%w: %s", ErrUnauthorized, marker)
+			return err
+		}
 	}
 
-	s.profilesQueue <- profileDetail
-	s.limiter.Done(1)
-
-	relatedProfiles, err := s.fetchRelatedProfiles(profile)
-
-	if err != nil {
-		logrus.WithField("profile", profile).Error("fetchRelatedProfiles failed")
-		return
-	}
+	return err
+}
 
-	s.jobsWg.Add(len(relatedProfiles))
+func (s *instagramSession) suggestedQueryHash() string {
+	// The query param to fetch suggested profiles
+	return "d4d88dc1500312af6f937f7b804c68c3"
+}
 
-	for _, relatedProfile := range relatedProfiles {
-		go s.crawl(relatedProfile)
-	}
+func (s *instagramSession) userAgent() string {
+	return "Mozilla/5.0 (X11; Linux x86_64; rv:88.0) Gecko/20100101 Firefox/88.0"
 }
 
-func (s *instagramSession) fetchProfileDetail(profile Profile) (Profile, error) {
+// FetchProfileDetail fetches full information of a profile by its username
+func (s *instagramSession) FetchProfileDetail(ctx context.Context, profile Profile) (Profile, error) {
 	type schema struct {
 		Graphql struct {
 			User instagramProfile
 		}
 	}
 
+	cookie, err := s.cookie()
+
+	if err != nil {
+		return Profile{}, err
+	}
+
 	resp, err := s.client.R().
+		SetContext(ctx).
 		SetPathParam("username", profile.Username).
 		SetQueryParam("__a", "1").
 		SetHeader("User-Agent", s.userAgent()).
-		SetCookie(s.cookie()).
+		SetCookie(cookie).
 		SetResult(&schema{}).
 		Get(s.baseURL() + "/{username}/")
 
@@ -155,14 +176,15 @@ func (s *instagramSession) fetchProfileDetail(profile Profile) (Profile, error)
 	}
 
 	if resp.StatusCode() != 200 {
-		return Profile{}, errors.New("fetch profile error")
+		return Profile{}, s.fetchError("fetch profile", profile, resp)
 	}
 
 	data, _ := resp.Result().(*schema)
 	return data.Graphql.User.toProfile(), nil
 }
 
-func (s *instagramSession) fetchRelatedProfiles(fromProfile Profile) ([]Profile, error) {
+// FetchRelatedProfiles fetches profiles suggested by instagram for `fromProfile`
+func (s *instagramSession) FetchRelatedProfiles(ctx context.Context, fromProfile Profile) ([]Profile, error) {
 	queryVariables := struct {
 		UserID                 string `json:"user_id"`
 		IncludeChaining        bool   `json:"include_chaining"`
@@ -195,13 +217,20 @@ func (s *instagramSession) fetchRelatedProfiles(fromProfile Profile) ([]Profile,
 		}
 	}
 
+	cookie, err := s.cookie()
+
+	if err != nil {
+		return nil, err
+	}
+
 	resp, err := s.client.R().
+		SetContext(ctx).
 		SetQueryParams(map[string]string{
 			"query_hash": s.suggestedQueryHash(),
 			"variables":  string(variables),
 		}).
 		SetHeader("User-Agent", s.userAgent()).
-		SetCookie(s.cookie()).
+		SetCookie(cookie).
 		SetResult(&schema{}).
 		Get(s.baseURL() + "/graphql/query")
 
@@ -210,7 +239,7 @@ func (s *instagramSession) fetchRelatedProfiles(fromProfile Profile) ([]Profile,
 	}
 
 	if resp.StatusCode() != 200 {
-		return nil, errors.New("fetch related profiles error")
+		return nil, s.fetchError("fetch related profiles", fromProfile, resp)
 	}
 
 	data, _ := resp.Result().(*schema)
@@ -225,6 +254,40 @@ func (s *instagramSession) fetchRelatedProfiles(fromProfile Profile) ([]Profile,
 
 /* Private stuffs */
 
+// instagramAuthMarkers are found in responses of requests needing a valid session
+var instagramAuthMarkers = []string{"login_required", "checkpoint_required", "challenge_required"}
+
+// maxRedirects mirrors the limit of the default http.Client redirect policy
+const maxRedirects = 10
+
+// stopAtLogin keeps the redirect to the login or challenge page as the response,
+// so that an expired session is reported instead of parsing the login page
+func stopAtLogin(req *http.Request, via []*http.Request) error {
+	if isLoginURL(req.URL.String()) {
+		return http.ErrUseLastResponse
+	}
+
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	return nil
+}
+
+func isLoginURL(rawURL string) bool {
+	if rawURL == "" {
+		return false
+	}
+
+	parsedURL, err := url.Parse(rawURL)
+
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(parsedURL.Path, "/accounts/login") || strings.HasPrefix(parsedURL.Path, "/challenge")
+}
+
 type instagramProfile struct {
 	FullName      string `json:"full_name"`
 	Username      string `json:"username"`
@@ -255,3 +318,33 @@ func (p instagramProfile) toProfile() Profile {
 		Username:    p.Username,
 	}
 }
+
+// feedbackTransport reports every round trip to `receive`, before handing it over to the caller
+type feedbackTransport struct {
+	base    http.RoundTripper
+	receive func(Feedback)
+}
+
+func (t *feedbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	startTime := time.Now()
+	resp, err := base.RoundTrip(req)
+	latency := time.Since(startTime)
+
+	if err != nil {
+		// Requests cancelled by the end of the run say nothing about the source
+		if req.Context().Err() == nil {
+			t.receive(Feedback{Latency: latency, Err: err})
+		}
+
+		return resp, err
+	}
+
+	t.receive(Feedback{StatusCode: resp.StatusCode, Latency: latency, Header: resp.Header})
+	return resp, nil
+}
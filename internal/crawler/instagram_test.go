@@ -1,11 +1,14 @@
 package crawler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/jarcoal/httpmock"
@@ -21,6 +24,9 @@ func TestNewInstagramCrawler(t *testing.T) {
 	_, err = NewInstagramCrawler(Config{Seed: fakeProfile}, limiterConfig)
 	assert.EqualError(t, err, "missing required Writer config")
 
+	_, err = NewInstagramCrawler(Config{Seeds: []Profile{fakeProfile, {ID: "2345"}}}, limiterConfig)
+	assert.EqualError(t, err, "invalid Seeds config: seed #1 has no Username, required by instagram")
+
 	config := Config{
 		Seed:   fakeProfile,
 		Writer: &mockWriter{},
@@ -74,7 +80,9 @@ func TestInstagramCrawlSuccess(t *testing.T) {
 	limiterConfig := LimiterConfig{MaxTakes: 5}
 	crawler, _ := NewInstagramCrawler(config, limiterConfig)
 
-	crawler.Run()
+	stats, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Greater(t, stats.BytesDownloaded, int64(0))
 
 	profileIDs := []string{}
 
@@ -86,6 +94,53 @@ func TestInstagramCrawlSuccess(t *testing.T) {
 	assert.Equal(t, []string{"1234", "2345", "3456", "4567", "5678"}, profileIDs)
 }
 
+func TestInstagramCrawlDeduplication(t *testing.T) {
+	client := &http.Client{}
+	httpmock.ActivateNonDefault(client)
+	defer httpmock.DeactivateAndReset()
+
+	for _, id := range []string{"1234", "2345", "3456"} {
+		profileResponder, _ := httpmock.NewJsonResponder(200, generateProfileDetailFixture(id))
+		httpmock.RegisterResponder("GET", fmt.Sprintf("/%s/?__a=1", "user_"+id), profileResponder)
+	}
+
+	// Every profile suggests all the others, including the seed
+	httpmock.RegisterResponder(
+		"GET",
+		"/graphql/query",
+		func(req *http.Request) (*http.Response, error) {
+			fixture := generateRelatedProfilesFixture("1234", "2345", "3456")
+			return httpmock.NewJsonResponse(200, fixture)
+		},
+	)
+
+	writer := &mockWriter{}
+	config := Config{
+		Client: client,
+		Seed:   fakeProfile,
+		Writer: writer,
+	}
+	crawler, _ := NewInstagramCrawler(config, LimiterConfig{MaxTakes: 10})
+
+	_, err := crawler.Run(context.Background())
+	assert.Equal(t, nil, err)
+
+	profileIDs := []string{}
+
+	for _, profile := range writer.WrittenProfiles {
+		profileIDs = append(profileIDs, profile.ID)
+	}
+
+	sort.Strings(profileIDs)
+	assert.Equal(t, []string{"1234", "2345", "3456"}, profileIDs)
+
+	callsCount := httpmock.GetCallCountInfo()
+	assert.Equal(t, 1, callsCount["GET /user_1234/?__a=1"])
+	assert.Equal(t, 1, callsCount["GET /user_2345/?__a=1"])
+	assert.Equal(t, 1, callsCount["GET /user_3456/?__a=1"])
+	assert.Equal(t, 3, callsCount["GET /graphql/query"])
+}
+
 func TestInstagramCrawlFailure(t *testing.T) {
 	client := &http.Client{}
 	httpmock.ActivateNonDefault(client)
@@ -101,7 +156,7 @@ func TestInstagramCrawlFailure(t *testing.T) {
 	crawler, _ := NewInstagramCrawler(config, limiterConfig)
 
 	// Fetch profile detail error
-	assert.NotPanics(t, crawler.Run)
+	assert.NotPanics(t, func() { _, _ = crawler.Run(context.Background()) })
 
 	profileResponder, _ := httpmock.NewJsonResponder(200, generateProfileDetailFixture(fakeID))
 	httpmock.RegisterResponder(
@@ -111,7 +166,7 @@ func TestInstagramCrawlFailure(t *testing.T) {
 	)
 
 	// Fetch related profiles error
-	assert.NotPanics(t, crawler.Run)
+	assert.NotPanics(t, func() { _, _ = crawler.Run(context.Background()) })
 }
 
 func TestFetchProfileDetail(t *testing.T) {
@@ -124,7 +179,7 @@ func TestFetchProfileDetail(t *testing.T) {
 	}
 
 	// No profile detail responder error
-	_, err := session.fetchProfileDetail(fakeProfile)
+	_, err := session.FetchProfileDetail(context.Background(), fakeProfile)
 	assert.NotEqual(t, nil, err)
 
 	httpmock.RegisterResponder(
@@ -133,8 +188,24 @@ func TestFetchProfileDetail(t *testing.T) {
 		httpmock.NewStringResponder(500, "Invalid"),
 	)
 
-	_, err = session.fetchProfileDetail(fakeProfile)
-	assert.EqualError(t, err, "fetch profile error")
+	_, err = session.FetchProfileDetail(context.Background(), fakeProfile)
+	assert.EqualError(t, err, "fetch profile error: status 500")
+
+	fetchErr := &FetchError{}
+	assert.True(t, errors.As(err, &fetchErr))
+	assert.Equal(t, 500, fetchErr.StatusCode)
+	assert.Equal(t, "Invalid", fetchErr.Body)
+	assert.Equal(t, fakeProfile, fetchErr.Profile)
+	assert.Equal(t, "https://www.instagram.com/user_1234/?__a=1", fetchErr.URL)
+
+	httpmock.RegisterResponder(
+		"GET",
+		fmt.Sprintf("/%s/?__a=1", fakeProfile.Username),
+		httpmock.NewStringResponder(404, "Not Found"),
+	)
+
+	_, err = session.FetchProfileDetail(context.Background(), fakeProfile)
+	assert.True(t, errors.Is(err, ErrNotFound))
 
 	profileResponder, _ := httpmock.NewJsonResponder(200, generateProfileDetailFixture(fakeID))
 	httpmock.RegisterResponder(
@@ -143,7 +214,7 @@ func TestFetchProfileDetail(t *testing.T) {
 		profileResponder,
 	)
 
-	profileDetail, err := session.fetchProfileDetail(fakeProfile)
+	profileDetail, err := session.FetchProfileDetail(context.Background(), fakeProfile)
 	assert.Equal(t, nil, err)
 	assert.Equal(t, fakeID, profileDetail.ID)
 	assert.Equal(t, "user_"+fakeID, profileDetail.Username)
@@ -160,7 +231,7 @@ func TestFetchRelatedProfiles(t *testing.T) {
 	}
 
 	// No related profiles responder error
-	_, err := session.fetchRelatedProfiles(fakeProfile)
+	_, err := session.FetchRelatedProfiles(context.Background(), fakeProfile)
 	assert.NotEqual(t, nil, err)
 
 	httpmock.RegisterResponder(
@@ -169,8 +240,17 @@ func TestFetchRelatedProfiles(t *testing.T) {
 		httpmock.NewStringResponder(500, "Invalid"),
 	)
 
-	_, err = session.fetchRelatedProfiles(fakeProfile)
-	assert.EqualError(t, err, "fetch related profiles error")
+	_, err = session.FetchRelatedProfiles(context.Background(), fakeProfile)
+	assert.EqualError(t, err, "fetch related profiles error: status 500")
+
+	httpmock.RegisterResponder(
+		"GET",
+		"/graphql/query",
+		httpmock.NewStringResponder(429, "Too Many Requests"),
+	)
+
+	_, err = session.FetchRelatedProfiles(context.Background(), fakeProfile)
+	assert.True(t, errors.Is(err, ErrRateLimited))
 
 	relatedProfilesResponder, _ := httpmock.NewJsonResponder(200, generateRelatedProfilesFixture("2345", "3456", "4567", "5678"))
 	httpmock.RegisterResponder(
@@ -179,7 +259,7 @@ func TestFetchRelatedProfiles(t *testing.T) {
 		relatedProfilesResponder,
 	)
 
-	relatedProfiles, err := session.fetchRelatedProfiles(fakeProfile)
+	relatedProfiles, err := session.FetchRelatedProfiles(context.Background(), fakeProfile)
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 4, len(relatedProfiles))
 	assert.Equal(t, "2345", relatedProfiles[0].ID)
@@ -188,6 +268,173 @@ func TestFetchRelatedProfiles(t *testing.T) {
 	assert.Equal(t, "5678", relatedProfiles[3].ID)
 }
 
+func TestInstagramCredentials(t *testing.T) {
+	client := &http.Client{}
+	httpmock.ActivateNonDefault(client)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder(
+		"GET",
+		fmt.Sprintf("/%s/?__a=1", fakeProfile.Username),
+		func(req *http.Request) (*http.Response, error) {
+			cookie, err := req.Cookie("sessionid")
+
+			if err != nil || cookie.Value != "fake-session-id" {
+				return httpmock.NewStringResponse(401, "Unauthorized"), nil
+			}
+
+			return httpmock.NewJsonResponse(200, generateProfileDetailFixture(fakeID))
+		},
+	)
+
+	session := instagramSession{
+		client:      resty.NewWithClient(client),
+		credentials: StaticCredentials("fake-session-id"),
+	}
+
+	_, err := session.FetchProfileDetail(context.Background(), fakeProfile)
+	assert.Equal(t, nil, err)
+
+	session.credentials = EnvCredentials{Key: "NSFW_TEST_MISSING"}
+
+	_, err = session.FetchProfileDetail(context.Background(), fakeProfile)
+	assert.EqualError(t, err, "env var NSFW_TEST_MISSING is empty")
+
+	_, err = session.FetchRelatedProfiles(context.Background(), fakeProfile)
+	assert.EqualError(t, err, "env var NSFW_TEST_MISSING is empty")
+}
+
+func TestInstagramRetry(t *testing.T) {
+	client := &http.Client{}
+	httpmock.ActivateNonDefault(client)
+	defer httpmock.DeactivateAndReset()
+
+	rateLimited := httpmock.NewStringResponse(429, "Too Many Requests")
+	rateLimited.Header.Set("Retry-After", "0")
+	profileResponse, _ := httpmock.NewJsonResponse(200, generateProfileDetailFixture(fakeID))
+
+	httpmock.RegisterResponder(
+		"GET",
+		fmt.Sprintf("/%s/?__a=1", fakeProfile.Username),
+		httpmock.ResponderFromMultipleResponses([]*http.Response{
+			httpmock.NewStringResponse(503, "Unavailable"),
+			rateLimited,
+			profileResponse,
+		}),
+	)
+
+	config := Config{
+		Client: client,
+		Retry: RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+		},
+	}
+	session := newInstagramSession(config)
+
+	profileDetail, err := session.FetchProfileDetail(context.Background(), fakeProfile)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, fakeID, profileDetail.ID)
+	assert.Equal(t, int64(2), session.Stats().Retries)
+
+	// Client errors are not retried
+	httpmock.RegisterResponder(
+		"GET",
+		"/graphql/query",
+		httpmock.ResponderFromMultipleResponses([]*http.Response{
+			httpmock.NewStringResponse(404, "Not Found"),
+			httpmock.NewStringResponse(200, "{}"),
+		}),
+	)
+
+	_, err = session.FetchRelatedProfiles(context.Background(), fakeProfile)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, int64(2), session.Stats().Retries)
+}
+
+func TestInstagramRetryExhausted(t *testing.T) {
+	client := &http.Client{}
+	httpmock.ActivateNonDefault(client)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder(
+		"GET",
+		"/graphql/query",
+		httpmock.NewStringResponder(500, "Invalid"),
+	)
+
+	config := Config{
+		Client: client,
+		Retry: RetryPolicy{
+			MaxAttempts: 2,
+			BaseDelay:   time.Millisecond,
+		},
+	}
+	session := newInstagramSession(config)
+
+	_, err := session.FetchRelatedProfiles(context.Background(), fakeProfile)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, int64(1), session.Stats().Retries)
+	assert.Equal(t, 2, httpmock.GetTotalCallCount())
+}
+
+func TestInstagramSessionExpired(t *testing.T) {
+	client := &http.Client{}
+	httpmock.ActivateNonDefault(client)
+	defer httpmock.DeactivateAndReset()
+
+	loginRedirect := httpmock.NewStringResponse(302, "")
+	loginRedirect.Header.Set("Location", "https://www.instagram.com/accounts/login/?next=/user_1234/")
+
+	httpmock.RegisterResponder(
+		"GET",
+		fmt.Sprintf("/%s/?__a=1", fakeProfile.Username),
+		httpmock.ResponderFromResponse(loginRedirect),
+	)
+	httpmock.RegisterResponder(
+		"GET",
+		"/graphql/query",
+		httpmock.NewStringResponder(400, `{"message": "checkpoint_required", "status": "fail"}`),
+	)
+
+	session := newInstagramSession(Config{Client: client})
+
+	// The login page is never requested
+	_, err := session.FetchProfileDetail(context.Background(), fakeProfile)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+	assert.EqualError(t, err, "fetch profile error: status 302: unauthorized: redirected to https://www.instagram.com/accounts/login/?next=/user_1234/")
+	assert.Equal(t, 1, httpmock.GetTotalCallCount())
+
+	_, err = session.FetchRelatedProfiles(context.Background(), fakeProfile)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+	assert.EqualError(t, err, "fetch related profiles error: status 400: unauthorized: checkpoint_required")
+}
+
+func TestInstagramSessionExpiredCrawl(t *testing.T) {
+	client := &http.Client{}
+	httpmock.ActivateNonDefault(client)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder(
+		"GET",
+		fmt.Sprintf("/%s/?__a=1", fakeProfile.Username),
+		httpmock.NewStringResponder(401, `{"message": "login_required"}`),
+	)
+
+	writer := &mockWriter{}
+	config := Config{
+		Client: client,
+		Seed:   fakeProfile,
+		Writer: writer,
+	}
+	crawler, _ := NewInstagramCrawler(config, LimiterConfig{MaxTakes: 10, MaxWorkers: 1, DeferTime: time.Millisecond})
+
+	stats, err := crawler.Run(context.Background())
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+	assert.Equal(t, []Profile{fakeProfile}, stats.Pending)
+	assert.Equal(t, 0, stats.Written)
+}
+
 func TestInstagramSessions(t *testing.T) {
 	session := instagramSession{}
 	assert.Equal(t, "https://www.instagram.com", session.baseURL())
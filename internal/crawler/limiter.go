@@ -1,28 +1,61 @@
 package crawler
 
 import (
+	"context"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
-// Limiter manages rate limiting based on time, max takes threshold and max background workers
+// Limiter paces takes at a rate, within a budget of max takes. Concurrency is bounded by `MaxConcurrency` instead.
+// Every successful take reserves a slot of the max takes budget, which must be either committed or released,
+// so that in-flight takes never overshoot the budget and failed ones give their slot back.
 type Limiter interface {
-	Done(int)
-	Take() bool
-	Wait()
+	// Commit counts a reserved slot towards the max takes threshold
+	Commit()
+	// Release gives a reserved slot back, to be reserved by another take
+	Release()
+	// Take blocks until the next allowed take and reserves a slot,
+	// or returns `false` once no more takes are allowed or `ctx` is done.
+	// While all remaining slots are reserved, it waits for one of them to be released.
+	Take(ctx context.Context) bool
+	// Close refuses all following takes and stops background goroutines, it is safe to call many times
+	Close()
+	// Reset reopens the limiter with a zero counter, so that it can be reused by another run
+	Reset()
 }
 
 // LimiterConfig contains configurations for a Limiter
+// @param DeferTime: time between ticks of the ticker limiter, 1ms if `0`
+// @param MaxTakes: upper limit of total takes, counted by `Commit`
+// @param MaxWorkers: takes allowed per tick of the ticker limiter, 1 if `0`
+// @param Rate: sustained takes per second, selects the token bucket limiter instead of the ticker one if positive,
+// initial rate of the adaptive limiter
+// @param Burst: takes allowed at once by the token bucket limiter after an idle period, 1 if `0`
+// @param MinRate: floor of the adaptive limiter rate, a tenth of `MaxRate` if `0`
+// @param MaxRate: ceiling of the adaptive limiter rate, selects the adaptive limiter if positive
 type LimiterConfig struct {
 	DeferTime  time.Duration
 	MaxTakes   int
 	MaxWorkers int
+	Rate       float64
+	Burst      int
+	MinRate    float64
+	MaxRate    float64
 }
 
-// NewLimiter creates a scheduler,
-// with an amount of time to wait between each take and an upper limit of total takes
+// NewLimiter creates a scheduler with an upper limit of total takes, pacing takes with either:
+// - an adaptive rate between `MinRate` and `MaxRate`, driven by the responses of the source, if `MaxRate` is positive
+// - a token bucket, refilled with `Rate` tokens per second up to `Burst` tokens, if `Rate` is positive
+// - a ticker, allowing `MaxWorkers` takes every `DeferTime`, otherwise
 func NewLimiter(config LimiterConfig) Limiter {
+	if config.MaxRate > 0 {
+		return newAdaptiveLimiter(config)
+	}
+
+	if config.Rate > 0 {
+		return newTokenBucket(config)
+	}
+
 	deferTime := config.DeferTime
 
 	if deferTime == 0 {
@@ -37,11 +70,11 @@ func NewLimiter(config LimiterConfig) Limiter {
 
 	l := &limiter{
 		deferTime:  deferTime,
-		maxTakes:   uint32(config.MaxTakes),
 		maxWorkers: maxWorkers,
 
-		throttle: make(chan struct{}),
-		wg:       &sync.WaitGroup{},
+		budget: newBudget(config.MaxTakes),
+
+		wg: &sync.WaitGroup{},
 	}
 
 	l.newThrottle()
@@ -49,68 +82,206 @@ func NewLimiter(config LimiterConfig) Limiter {
 	return l
 }
 
-// Done increases the profiles counter by `delta`
-func (l *limiter) Done(delta int) {
-	atomic.AddUint32(&l.takesCounter, uint32(delta))
+// Commit increases the profiles counter by one reserved slot
+func (l *limiter) Commit() {
+	l.budget.settle(true)
+}
+
+// Release gives a reserved slot back
+func (l *limiter) Release() {
+	l.budget.settle(false)
 }
 
-// Take blocks until the next allowing time,
-// or return `false` if max profiles exceeded.
-func (l *limiter) Take() bool {
-	_, ok := <-l.throttle
-	return ok
+// Take reserves a slot, then blocks until the next allowing time,
+// or return `false` if max profiles exceeded, the limiter is closed or `ctx` is done.
+func (l *limiter) Take(ctx context.Context) bool {
+	l.mu.Lock()
+	throttle, stop, closed := l.throttle, l.stop, l.closed
+	l.mu.Unlock()
+
+	// Tokens still buffered by the throttle aren't served once closed
+	if closed || !l.budget.reserve(ctx, stop) {
+		return false
+	}
+
+	select {
+	case _, ok := <-throttle:
+		if ok {
+			return true
+		}
+	case <-ctx.Done():
+	}
+
+	l.budget.settle(false)
+	return false
 }
 
-// Wait checks if the profiles counter didn't exceed `maxTakes`,
-// then add up to gracefully exit the throttle goroutine
-func (l *limiter) Wait() {
-	atomicCounter := atomic.LoadUint32(&l.takesCounter)
+// Close stops the throttle goroutine right away, and waits for it to exit
+func (l *limiter) Close() {
+	l.mu.Lock()
 
-	if atomicCounter < l.maxTakes {
-		atomic.AddUint32(&l.takesCounter, l.maxTakes-atomicCounter)
+	if !l.closed {
+		l.closed = true
+		close(l.stop)
 	}
 
+	l.mu.Unlock()
+
 	l.wg.Wait()
 }
 
+// Reset closes the limiter, then starts a new throttle goroutine with a zero counter
+func (l *limiter) Reset() {
+	l.Close()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.budget.reset()
+	l.newThrottle()
+}
+
 /* Private stuffs */
 
 type limiter struct {
 	// Received configurations
 	deferTime  time.Duration
-	maxTakes   uint32
 	maxWorkers int
 
-	// takesCounter: atomic counter to keep track of crawled profiles
-	// throttle: limit concurrent jobs by time and `maxTakes`
+	// budget: slots of max takes reserved and committed by crawled profiles
+	// mu: guards the channels and `closed`, which are replaced by Reset
+	// throttle: limit concurrent jobs by time and `maxTakes`, closed once no more takes are allowed
+	// stop: closed by Close to stop the throttle goroutine
 	// wg: wait for throttle goroutine to be done
-	takesCounter uint32
-	throttle     chan struct{}
-	wg           *sync.WaitGroup
+	budget   *budget
+	mu       sync.Mutex
+	throttle chan struct{}
+	stop     chan struct{}
+	closed   bool
+	wg       *sync.WaitGroup
 }
 
+// newThrottle starts the throttle goroutine, `mu` must be held or the limiter not shared yet
 func (l *limiter) newThrottle() {
-	l.throttle = make(chan struct{}, l.maxWorkers)
+	throttle := make(chan struct{}, l.maxWorkers)
+	stop := make(chan struct{})
+
+	l.throttle = throttle
+	l.stop = stop
+	l.closed = false
 
 	l.wg.Add(1)
 
 	go func() {
 		defer l.wg.Done()
+		defer close(throttle)
 
-		ticker := time.NewTicker(l.deferTime).C
+		ticker := time.NewTicker(l.deferTime)
+		defer ticker.Stop()
 
 		for {
-			<-ticker
-			atomicCounter := atomic.LoadUint32(&l.takesCounter)
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
 
-			if atomicCounter >= l.maxTakes {
-				close(l.throttle)
+			if l.budget.exhausted() {
 				return
 			}
 
+			// Sends never block, so that an idle throttle still stops right away on Close.
+			// Tokens left from previous ticks are kept, up to `maxWorkers`.
 			for worker := 0; worker < l.maxWorkers; worker++ {
-				l.throttle <- struct{}{}
+				select {
+				case throttle <- struct{}{}:
+				default:
+				}
 			}
 		}
 	}()
 }
+
+// newBudget creates a budget of `maxTakes` slots
+func newBudget(maxTakes int) *budget {
+	return &budget{maxTakes: maxTakes, settled: make(chan struct{})}
+}
+
+// budget counts the slots of max takes shared by all Limiter implementations.
+// Reserved slots are in-flight takes, not counted towards `maxTakes` until they are committed.
+type budget struct {
+	maxTakes int
+
+	// mu: guards all following fields
+	// settled: closed and replaced every time a slot is committed or released, to wake up waiting takes
+	mu        sync.Mutex
+	reserved  int
+	committed int
+	settled   chan struct{}
+}
+
+// reserve blocks until a slot is free and reserves it, or returns `false` once all slots are committed,
+// `stop` is closed or `ctx` is done
+func (b *budget) reserve(ctx context.Context, stop <-chan struct{}) bool {
+	for {
+		b.mu.Lock()
+
+		if b.committed >= b.maxTakes {
+			b.mu.Unlock()
+			return false
+		}
+
+		if b.committed+b.reserved < b.maxTakes {
+			b.reserved++
+			b.mu.Unlock()
+			return true
+		}
+
+		settled := b.settled
+		b.mu.Unlock()
+
+		select {
+		case <-settled:
+		case <-stop:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// settle commits or releases a reserved slot, unbalanced calls are ignored
+func (b *budget) settle(commit bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.reserved == 0 {
+		return
+	}
+
+	b.reserved--
+
+	if commit {
+		b.committed++
+	}
+
+	close(b.settled)
+	b.settled = make(chan struct{})
+}
+
+// exhausted reports whether all slots are committed
+func (b *budget) exhausted() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.committed >= b.maxTakes
+}
+
+// reset frees all slots
+func (b *budget) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.reserved = 0
+	b.committed = 0
+}
@@ -1,7 +1,9 @@
 package crawler
 
 import (
+	"context"
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,7 +17,7 @@ func TestLimiterMaxTakesExceed(t *testing.T) {
 
 	limiter := NewLimiter(LimiterConfig{DeferTime: deferTime, MaxTakes: 4})
 	numbers, subs := mockProducer(10, limiter)
-	limiter.Wait()
+	limiter.Close()
 
 	assert.Equal(t, []int{0, 1, 2, 3}, numbers)
 
@@ -25,8 +27,7 @@ func TestLimiterMaxTakesExceed(t *testing.T) {
 		assert.Less(t, diff, deferTime+5*time.Millisecond)
 	}
 
-	time.Sleep(deferTime)
-	assert.Equal(t, initialGoRoutines, runtime.NumGoroutine())
+	assertNoLeakedGoroutines(t, initialGoRoutines)
 }
 
 func TestLimiterMaxTakesNotExceed(t *testing.T) {
@@ -34,14 +35,169 @@ func TestLimiterMaxTakesNotExceed(t *testing.T) {
 
 	limiter := NewLimiter(LimiterConfig{MaxTakes: 4})
 	numbers, _ := mockProducer(3, limiter)
-	limiter.Wait()
+	limiter.Close()
 
 	assert.Equal(t, []int{0, 1, 2}, numbers)
-	assert.Equal(t, initialGoRoutines, runtime.NumGoroutine())
+	assertNoLeakedGoroutines(t, initialGoRoutines)
+}
+
+func TestLimiterTakeCancellation(t *testing.T) {
+	for name, config := range limiterConfigs(time.Hour) {
+		limiter := NewLimiter(config)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		startTime := time.Now()
+
+		// Drains what is allowed right away, then gives up on cancellation instead of waiting for an hour
+		for limiter.Take(ctx) {
+		}
+
+		assert.NotEqual(t, nil, ctx.Err(), name)
+		assert.Less(t, time.Since(startTime), 100*time.Millisecond, name)
+
+		cancel()
+		limiter.Close()
+	}
+}
+
+func TestLimiterReservation(t *testing.T) {
+	for name, config := range limiterConfigs(time.Millisecond) {
+		limiter := NewLimiter(config)
+
+		for take := 0; take < 4; take++ {
+			assert.Equal(t, true, limiter.Take(context.Background()), name)
+		}
+
+		// All slots are reserved, so the next take waits for one of them to be settled
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		assert.Equal(t, false, limiter.Take(ctx), name)
+		cancel()
+
+		// A released slot can be taken again
+		limiter.Release()
+		assert.Equal(t, true, limiter.Take(context.Background()), name)
+
+		// A waiting take is refused as soon as all slots are committed
+		refused := make(chan bool)
+
+		go func() { refused <- !limiter.Take(context.Background()) }()
+
+		time.Sleep(10 * time.Millisecond)
+
+		for take := 0; take < 4; take++ {
+			limiter.Commit()
+		}
+
+		assert.Equal(t, true, <-refused, name)
+		assert.Equal(t, false, limiter.Take(context.Background()), name)
+		limiter.Close()
+	}
+}
+
+func TestLimiterClose(t *testing.T) {
+	for name, config := range limiterConfigs(time.Hour) {
+		initialGoRoutines := runtime.NumGoroutine()
+		limiter := NewLimiter(config)
+
+		// Takes waiting for the next tick are released right away
+		released := false
+		wg := sync.WaitGroup{}
+		startTime := time.Now()
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			// Drains what is allowed right away, then waits
+			for limiter.Take(context.Background()) {
+			}
+
+			released = true
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		limiter.Close()
+		limiter.Close()
+		wg.Wait()
+
+		assert.Equal(t, true, released, name)
+		assert.Less(t, time.Since(startTime), 100*time.Millisecond, name)
+		assert.Equal(t, false, limiter.Take(context.Background()), name)
+		assertNoLeakedGoroutines(t, initialGoRoutines, name)
+	}
+}
+
+func TestLimiterIdleTicks(t *testing.T) {
+	initialGoRoutines := runtime.NumGoroutine()
+	limiter := NewLimiter(LimiterConfig{DeferTime: time.Millisecond, MaxWorkers: 2, MaxTakes: 10})
+
+	// Ticks keep coming while nothing is taken, the tokens of previous ticks are kept
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, true, limiter.Take(context.Background()))
+	limiter.Commit()
+
+	// Idle ticks never block the throttle goroutine, which stops right away on Close
+	time.Sleep(20 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		limiter.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked by an idle tick")
+	}
+
+	assertNoLeakedGoroutines(t, initialGoRoutines)
+}
+
+func TestLimiterReset(t *testing.T) {
+	for name, config := range limiterConfigs(time.Millisecond) {
+		initialGoRoutines := runtime.NumGoroutine()
+		limiter := NewLimiter(config)
+
+		// Every run gets the whole max takes again
+		for run := 0; run < 3; run++ {
+			limiter.Reset()
+
+			numbers, _ := mockProducer(10, limiter)
+			assert.Equal(t, []int{0, 1, 2, 3}, numbers, name)
+		}
+
+		limiter.Close()
+		assertNoLeakedGoroutines(t, initialGoRoutines, name)
+	}
 }
 
 /* Private stuffs */
 
+// assertNoLeakedGoroutines waits for the goroutines started since `initialGoRoutines` was counted to exit,
+// since closed limiters may take a moment to wind theirs down.
+// It polls in place, `assert.Eventually` would count its own goroutine.
+func assertNoLeakedGoroutines(t *testing.T, initialGoRoutines int, msgAndArgs ...interface{}) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+
+	for runtime.NumGoroutine() > initialGoRoutines && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.LessOrEqual(t, runtime.NumGoroutine(), initialGoRoutines, msgAndArgs...)
+}
+
+// limiterConfigs returns a config of every Limiter implementation, allowing 4 takes at `deferTime` intervals
+func limiterConfigs(deferTime time.Duration) map[string]LimiterConfig {
+	return map[string]LimiterConfig{
+		"ticker":       {DeferTime: deferTime, MaxTakes: 4},
+		"token bucket": {Rate: float64(time.Second) / float64(deferTime), MaxTakes: 4},
+	}
+}
+
 func mockProducer(amount int, limiter Limiter) ([]int, []time.Duration) {
 	numbersCh := make(chan []int)
 	subsCh := make(chan []time.Duration)
@@ -52,7 +208,7 @@ func mockProducer(amount int, limiter Limiter) ([]int, []time.Duration) {
 		subs := []time.Duration{}
 
 		for num := 0; num < amount; num++ {
-			ok := limiter.Take()
+			ok := limiter.Take(context.Background())
 			now := time.Now()
 
 			if !ok {
@@ -61,7 +217,7 @@ func mockProducer(amount int, limiter Limiter) ([]int, []time.Duration) {
 
 			numbers = append(numbers, num)
 			subs = append(subs, now.Sub(start))
-			limiter.Done(1)
+			limiter.Commit()
 		}
 
 		numbersCh <- numbers
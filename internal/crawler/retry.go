@@ -0,0 +1,182 @@
+package crawler
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// RetryPolicy configures how requests failed with transport errors, HTTP 429 or 5xx are retried
+// @param MaxAttempts: total attempts per request including the first one, no retries if <= 1
+// @param BaseDelay: delay before the first retry, doubled on every following retry, 500ms if `0`
+// @param MaxDelay: upper bound of a single delay, including `Retry-After` values, 30s if `0`
+// @param Jitter: ratio in [0, 1] of the delay which is randomly shaved off, to spread retries
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+// Delay returns how long to wait before retrying after the given failed `attempt`, starting from 1.
+// A `Retry-After` value from the server takes precedence over the exponential backoff.
+func (p RetryPolicy) Delay(attempt int, retryAfter time.Duration) time.Duration {
+	p = p.withDefaults()
+
+	if retryAfter > 0 {
+		return minDuration(retryAfter, p.MaxDelay)
+	}
+
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	backoff := float64(p.BaseDelay) * math.Exp2(float64(attempt-1))
+	delay := time.Duration(math.Min(backoff, float64(p.MaxDelay)))
+
+	if p.Jitter > 0 {
+		delay -= time.Duration(float64(delay) * math.Min(p.Jitter, 1) * rand.Float64())
+	}
+
+	return delay
+}
+
+// ParseRetryAfter reads a `Retry-After` header, in seconds or as an HTTP date, `0` if missing or invalid
+func ParseRetryAfter(header http.Header, now time.Time) time.Duration {
+	value := header.Get("Retry-After")
+
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
+
+// RateLimit is the state of a rate limit window advertised by the server
+// @param Remaining: requests still allowed in the current window
+// @param Reset: time until the window resets
+type RateLimit struct {
+	Remaining int
+	Reset     time.Duration
+}
+
+// ParseRateLimit reads `RateLimit-Remaining` and `RateLimit-Reset` headers, or their `X-RateLimit-` variants.
+// Reset values are either seconds until the reset, or a Unix timestamp.
+// It reports `false` if the remaining requests are missing or invalid.
+func ParseRateLimit(header http.Header, now time.Time) (RateLimit, bool) {
+	for _, prefix := range []string{"RateLimit-", "X-RateLimit-"} {
+		remaining, err := strconv.Atoi(header.Get(prefix + "Remaining"))
+
+		if err != nil || remaining < 0 {
+			continue
+		}
+
+		rateLimit := RateLimit{Remaining: remaining}
+
+		if reset, err := strconv.ParseInt(header.Get(prefix+"Reset"), 10, 64); err == nil && reset > 0 {
+			// Seconds values are small, anything bigger than a year is a timestamp
+			if reset > unixTimestampThreshold {
+				rateLimit.Reset = time.Unix(reset, 0).Sub(now)
+			} else {
+				rateLimit.Reset = time.Duration(reset) * time.Second
+			}
+		}
+
+		if rateLimit.Reset < 0 {
+			rateLimit.Reset = 0
+		}
+
+		return rateLimit, true
+	}
+
+	return RateLimit{}, false
+}
+
+/* Private stuffs */
+
+// unixTimestampThreshold: rate limit reset values above it are Unix timestamps rather than seconds
+const unixTimestampThreshold = 365 * 24 * 60 * 60
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = 500 * time.Millisecond
+	}
+
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 30 * time.Second
+	}
+
+	if p.MaxDelay < p.BaseDelay {
+		p.MaxDelay = p.BaseDelay
+	}
+
+	return p
+}
+
+// applyRetryPolicy configures `client` to retry following `policy`, calling `onRetry` before every retry
+func applyRetryPolicy(client *resty.Client, policy RetryPolicy, onRetry func()) {
+	if policy.MaxAttempts <= 1 {
+		return
+	}
+
+	policy = policy.withDefaults()
+
+	client.
+		SetRetryCount(policy.MaxAttempts - 1).
+		SetRetryWaitTime(0).
+		SetRetryMaxWaitTime(policy.MaxDelay).
+		AddRetryCondition(func(resp *resty.Response, err error) bool {
+			if err != nil {
+				return true
+			}
+
+			return resp.StatusCode() == http.StatusTooManyRequests || resp.StatusCode() >= 500
+		}).
+		SetRetryAfter(func(_ *resty.Client, resp *resty.Response) (time.Duration, error) {
+			retryAfter := ParseRetryAfter(resp.Header(), time.Now())
+			return policy.Delay(resp.Request.Attempt, retryAfter), nil
+		}).
+		AddRetryHook(func(resp *resty.Response, err error) {
+			// Hooks run after the last attempt as well, which isn't followed by any retry
+			if resp != nil && resp.Request.Attempt >= policy.MaxAttempts {
+				return
+			}
+
+			onRetry()
+
+			fields := logrus.Fields{"error": err}
+
+			if resp != nil {
+				fields["attempt"] = resp.Request.Attempt
+				fields["status"] = resp.StatusCode()
+				fields["url"] = resp.Request.URL
+			}
+
+			logrus.WithFields(fields).Warn("retrying request")
+		})
+}
+
+func minDuration(a time.Duration, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,94 @@
+package crawler
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{
+		BaseDelay: 100 * time.Millisecond,
+		MaxDelay:  time.Second,
+	}
+
+	assert.Equal(t, 100*time.Millisecond, policy.Delay(1, 0))
+	assert.Equal(t, 200*time.Millisecond, policy.Delay(2, 0))
+	assert.Equal(t, 400*time.Millisecond, policy.Delay(3, 0))
+	assert.Equal(t, time.Second, policy.Delay(5, 0))
+
+	// Retry-After takes precedence, capped by MaxDelay
+	assert.Equal(t, 300*time.Millisecond, policy.Delay(1, 300*time.Millisecond))
+	assert.Equal(t, time.Second, policy.Delay(1, time.Minute))
+
+	// Defaults
+	assert.Equal(t, 500*time.Millisecond, RetryPolicy{}.Delay(1, 0))
+	assert.Equal(t, 30*time.Second, RetryPolicy{}.Delay(10, 0))
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	policy := RetryPolicy{
+		BaseDelay: 100 * time.Millisecond,
+		Jitter:    0.5,
+	}
+
+	for idx := 0; idx < 100; idx++ {
+		delay := policy.Delay(2, 0)
+		assert.GreaterOrEqual(t, int64(delay), int64(100*time.Millisecond))
+		assert.LessOrEqual(t, int64(delay), int64(200*time.Millisecond))
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	header := http.Header{}
+
+	assert.Equal(t, time.Duration(0), ParseRetryAfter(header, now))
+
+	header.Set("Retry-After", "5")
+	assert.Equal(t, 5*time.Second, ParseRetryAfter(header, now))
+
+	header.Set("Retry-After", now.Add(time.Minute).Format(http.TimeFormat))
+	assert.Equal(t, time.Minute, ParseRetryAfter(header, now))
+
+	header.Set("Retry-After", now.Add(-time.Minute).Format(http.TimeFormat))
+	assert.Equal(t, time.Duration(0), ParseRetryAfter(header, now))
+
+	header.Set("Retry-After", "-1")
+	assert.Equal(t, time.Duration(0), ParseRetryAfter(header, now))
+
+	header.Set("Retry-After", "soon")
+	assert.Equal(t, time.Duration(0), ParseRetryAfter(header, now))
+}
+
+func TestParseRateLimit(t *testing.T) {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	header := http.Header{}
+
+	_, ok := ParseRateLimit(header, now)
+	assert.Equal(t, false, ok)
+
+	header.Set("X-RateLimit-Remaining", "10")
+	header.Set("X-RateLimit-Reset", "30")
+	rateLimit, ok := ParseRateLimit(header, now)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, RateLimit{Remaining: 10, Reset: 30 * time.Second}, rateLimit)
+
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(now.Add(time.Minute).Unix(), 10))
+	rateLimit, _ = ParseRateLimit(header, now)
+	assert.Equal(t, RateLimit{Remaining: 10, Reset: time.Minute}, rateLimit)
+
+	// Standard headers take precedence over the `X-` variants
+	header.Set("RateLimit-Remaining", "0")
+	header.Set("RateLimit-Reset", "5")
+	rateLimit, _ = ParseRateLimit(header, now)
+	assert.Equal(t, RateLimit{Remaining: 0, Reset: 5 * time.Second}, rateLimit)
+
+	header = http.Header{}
+	header.Set("RateLimit-Remaining", "many")
+	_, ok = ParseRateLimit(header, now)
+	assert.Equal(t, false, ok)
+}
@@ -0,0 +1,153 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Formats of seed files supported by ReadSeeds
+const (
+	SeedsFormatLines = "lines"
+	SeedsFormatCSV   = "csv"
+	SeedsFormatJSON  = "json"
+)
+
+// LoadSeeds reads seed profiles from a file, detecting its format by the extension:
+// `.csv` for SeedsFormatCSV, `.json` for SeedsFormatJSON and SeedsFormatLines otherwise
+func LoadSeeds(path string) ([]Profile, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	format := SeedsFormatLines
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		format = SeedsFormatCSV
+	case ".json":
+		format = SeedsFormatJSON
+	}
+
+	return ReadSeeds(file, format)
+}
+
+// ReadSeeds parses seed profiles from `reader` in the given format:
+// - SeedsFormatLines: one username or numeric ID per line, blank lines and `#` comments are ignored
+// - SeedsFormatCSV: `id,username` rows, with an optional header row
+// - SeedsFormatJSON: an array of `{"id": ..., "username": ...}` objects
+func ReadSeeds(reader io.Reader, format string) ([]Profile, error) {
+	switch format {
+	case SeedsFormatLines:
+		return readSeedsLines(reader)
+	case SeedsFormatCSV:
+		return readSeedsCSV(reader)
+	case SeedsFormatJSON:
+		return readSeedsJSON(reader)
+	default:
+		return nil, fmt.Errorf("unknown seeds format %q", format)
+	}
+}
+
+/* Private stuffs */
+
+func readSeedsLines(reader io.Reader) ([]Profile, error) {
+	profiles := []Profile{}
+	scanner := bufio.NewScanner(reader)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if isNumeric(line) {
+			profiles = append(profiles, Profile{ID: line})
+		} else {
+			profiles = append(profiles, Profile{Username: strings.TrimPrefix(line, "@")})
+		}
+	}
+
+	return profiles, scanner.Err()
+}
+
+func readSeedsCSV(reader io.Reader) ([]Profile, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	rows, err := csvReader.ReadAll()
+
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := []Profile{}
+
+	for idx, row := range rows {
+		if idx == 0 && len(row) > 0 && strings.EqualFold(row[0], "id") {
+			continue
+		}
+
+		profile := Profile{}
+
+		if len(row) > 0 {
+			profile.ID = strings.TrimSpace(row[0])
+		}
+
+		if len(row) > 1 {
+			profile.Username = strings.TrimSpace(row[1])
+		}
+
+		if profile.ID == "" && profile.Username == "" {
+			continue
+		}
+
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
+
+func readSeedsJSON(reader io.Reader) ([]Profile, error) {
+	records := []struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	}{}
+
+	if err := json.NewDecoder(reader).Decode(&records); err != nil {
+		return nil, err
+	}
+
+	profiles := []Profile{}
+
+	for _, record := range records {
+		if record.ID == "" && record.Username == "" {
+			continue
+		}
+
+		profiles = append(profiles, Profile{ID: record.ID, Username: record.Username})
+	}
+
+	return profiles, nil
+}
+
+func isNumeric(value string) bool {
+	for _, char := range value {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+
+	return value != ""
+}
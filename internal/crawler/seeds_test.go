@@ -0,0 +1,83 @@
+package crawler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadSeedsLines(t *testing.T) {
+	input := `
+		# seeds
+		3030197091
+		vox.ngoc.traan
+		@fake.user
+	`
+
+	profiles, err := ReadSeeds(strings.NewReader(input), SeedsFormatLines)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Profile{
+		{ID: "3030197091"},
+		{Username: "vox.ngoc.traan"},
+		{Username: "fake.user"},
+	}, profiles)
+}
+
+func TestReadSeedsCSV(t *testing.T) {
+	input := "id,username\n1234,user_1234\n,user_2345\n3456\n,\n"
+
+	profiles, err := ReadSeeds(strings.NewReader(input), SeedsFormatCSV)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Profile{
+		{ID: "1234", Username: "user_1234"},
+		{Username: "user_2345"},
+		{ID: "3456"},
+	}, profiles)
+
+	_, err = ReadSeeds(strings.NewReader(`"broken`), SeedsFormatCSV)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestReadSeedsJSON(t *testing.T) {
+	input := `[{"id": "1234", "username": "user_1234"}, {"username": "user_2345"}, {}]`
+
+	profiles, err := ReadSeeds(strings.NewReader(input), SeedsFormatJSON)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Profile{
+		{ID: "1234", Username: "user_1234"},
+		{Username: "user_2345"},
+	}, profiles)
+
+	_, err = ReadSeeds(strings.NewReader(`{"id": 1}`), SeedsFormatJSON)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestReadSeedsUnknownFormat(t *testing.T) {
+	_, err := ReadSeeds(strings.NewReader(""), "xml")
+	assert.EqualError(t, err, `unknown seeds format "xml"`)
+}
+
+func TestLoadSeeds(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"seeds.txt":  "1234\n",
+		"seeds.csv":  "1234,user_1234\n",
+		"seeds.json": `[{"id": "1234"}]`,
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		_ = os.WriteFile(path, []byte(content), 0600)
+
+		profiles, err := LoadSeeds(path)
+		assert.Equal(t, nil, err, name)
+		assert.Equal(t, "1234", profiles[0].ID, name)
+	}
+
+	_, err := LoadSeeds(filepath.Join(dir, "missing.txt"))
+	assert.NotEqual(t, nil, err)
+}
@@ -0,0 +1,92 @@
+package crawler
+
+import (
+	"sync"
+	"time"
+)
+
+// RunStats summarises the outcome of a crawler run
+// @param Fetched: profiles fetched successfully
+// @param Written: profiles written successfully
+// @param DetailFailures: profiles failed to be fetched
+// @param NotFound: profiles skipped because they don't exist anymore
+// @param Requeued: profiles put back to the frontier after being rate limited
+// @param RelatedFailures: profiles failed to fetch their related profiles
+// @param WriteFailures: profiles and edges failed to be written
+// @param EdgesWritten: edges written successfully, if the writer is an EdgeWriter
+// @param WriteStalls: crawl jobs blocked because the write buffer was full
+// @param WriteStallTime: total time crawl jobs spent blocked on the write buffer
+// @param WriteBufferPeak: the most profiles and edge batches waiting to be written at once
+// @param Duplicates: related profiles skipped because they were already visited
+// @param BytesDownloaded: response bytes received by the source, if reported
+// @param Retries: requests retried by the source, if reported
+// @param Duration: wall time of the run
+// @param MaxDepth: the deepest hop from the seed reached by a fetched profile
+// @param Pending: profiles discovered but not fetched when the run stopped, a crawl can be resumed from them
+type RunStats struct {
+	Fetched         int
+	Written         int
+	DetailFailures  int
+	NotFound        int
+	Requeued        int
+	RelatedFailures int
+	WriteFailures   int
+	EdgesWritten    int
+	WriteStalls     int
+	WriteStallTime  time.Duration
+	WriteBufferPeak int
+	Duplicates      int
+	BytesDownloaded int64
+	Retries         int64
+	Duration        time.Duration
+	MaxDepth        int
+	Pending         []Profile
+}
+
+// Failures returns the total amount of failures across all stages
+func (s RunStats) Failures() int {
+	return s.DetailFailures + s.RelatedFailures + s.WriteFailures
+}
+
+// SourceStats holds cumulative metrics collected by a Source
+type SourceStats struct {
+	BytesDownloaded int64
+	Retries         int64
+}
+
+// StatsReporter is implemented by sources able to report their own metrics
+type StatsReporter interface {
+	Stats() SourceStats
+}
+
+/* Private stuffs */
+
+// statsRecorder guards RunStats against concurrent crawl jobs
+type statsRecorder struct {
+	mu    sync.Mutex
+	stats RunStats
+}
+
+func (r *statsRecorder) record(update func(*RunStats)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	update(&r.stats)
+}
+
+func (r *statsRecorder) snapshot() RunStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stats
+}
+
+func sourceStats(source Source) SourceStats {
+	reporter, ok := source.(StatsReporter)
+
+	if !ok {
+		return SourceStats{}
+	}
+
+	return reporter.Stats()
+}
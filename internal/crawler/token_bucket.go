@@ -0,0 +1,162 @@
+package crawler
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// newTokenBucket creates a token bucket limiter, see tokenBucket
+func newTokenBucket(config LimiterConfig) *tokenBucket {
+	burst := config.Burst
+
+	if burst <= 0 {
+		burst = 1
+	}
+
+	// The bucket starts full, so that the first `burst` takes don't wait
+	return &tokenBucket{
+		rate:       config.Rate,
+		burst:      float64(burst),
+		budget:     newBudget(config.MaxTakes),
+		tokens:     float64(burst),
+		refilledAt: time.Now(),
+		stop:       make(chan struct{}),
+	}
+}
+
+// Commit increases the profiles counter by one reserved slot
+func (b *tokenBucket) Commit() {
+	b.budget.settle(true)
+}
+
+// Release gives a reserved slot back, the token consumed by its take isn't refunded
+func (b *tokenBucket) Release() {
+	b.budget.settle(false)
+}
+
+// Take reserves a slot, then blocks until a token is available,
+// or returns `false` if max profiles exceeded, the limiter is closed or `ctx` is done.
+func (b *tokenBucket) Take(ctx context.Context) bool {
+	stop := b.stopChan()
+
+	if !b.budget.reserve(ctx, stop) {
+		return false
+	}
+
+	if b.takeToken(ctx, stop) {
+		return true
+	}
+
+	b.budget.settle(false)
+	return false
+}
+
+// Close refuses all following takes, and releases the ones waiting for a token.
+// There is no background goroutine to stop, unlike the ticker limiter.
+func (b *tokenBucket) Close() {
+	b.stopMu.Lock()
+	defer b.stopMu.Unlock()
+
+	select {
+	case <-b.stop:
+	default:
+		close(b.stop)
+	}
+}
+
+// Reset closes the limiter, then reopens it with a full bucket and a zero counter
+func (b *tokenBucket) Reset() {
+	b.Close()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stopMu.Lock()
+	defer b.stopMu.Unlock()
+
+	b.budget.reset()
+	b.tokens = b.burst
+	b.refilledAt = time.Now()
+	b.stop = make(chan struct{})
+}
+
+/* Private stuffs */
+
+// tokenBucket is a Limiter holding up to `burst` tokens, refilled continuously at `rate` tokens per second.
+// Every take consumes a token, so takes are sustained at `rate` per second on average,
+// and up to `burst` takes are allowed at once after an idle period instead of wasting its capacity.
+type tokenBucket struct {
+	// Received configurations
+	rate  float64
+	burst float64
+
+	// budget: slots of max takes reserved and committed by crawled profiles
+	// mu: guards `tokens` and `refilledAt`, takes are served one at a time
+	// stop: closed by Close, releases takes waiting for a token, replaced by Reset under `stopMu`
+	budget     *budget
+	mu         sync.Mutex
+	tokens     float64
+	refilledAt time.Time
+	stopMu     sync.Mutex
+	stop       chan struct{}
+}
+
+var _ Limiter = (*tokenBucket)(nil)
+
+func (b *tokenBucket) stopChan() chan struct{} {
+	b.stopMu.Lock()
+	defer b.stopMu.Unlock()
+
+	return b.stop
+}
+
+// takeToken blocks until a token is available and consumes it, or returns `false` if `stop` is closed or `ctx` is done
+func (b *tokenBucket) takeToken(ctx context.Context, stop chan struct{}) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for {
+		if b.closed(stop) || ctx.Err() != nil {
+			return false
+		}
+
+		b.refill(time.Now())
+
+		if b.tokens >= 1 {
+			b.tokens--
+			return true
+		}
+
+		// Sleep until the missing fraction of a token is refilled, other takes queue up on `mu`
+		wait := time.Duration(math.Ceil((1 - b.tokens) / b.rate * float64(time.Second)))
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return false
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+	}
+}
+
+func (b *tokenBucket) closed(stop chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// refill adds the tokens accumulated since the last refill, up to `burst`, `mu` must be held
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.refilledAt).Seconds()
+	b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+	b.refilledAt = now
+}
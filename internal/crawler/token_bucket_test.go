@@ -0,0 +1,81 @@
+package crawler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketAverageRate(t *testing.T) {
+	limiter := NewLimiter(LimiterConfig{Rate: 200, Burst: 1, MaxTakes: 100})
+	numbers, subs := mockProducer(41, limiter)
+	limiter.Close()
+
+	// The first take is served by the full bucket, the 40 following ones are paced at 5ms
+	assert.Equal(t, 41, len(numbers))
+	assert.Greater(t, subs[40], 195*time.Millisecond)
+	assert.Less(t, subs[40], 300*time.Millisecond)
+	assertBurstBound(t, subs, 200, 1)
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	limiter := NewLimiter(LimiterConfig{Rate: 20, Burst: 5, MaxTakes: 100})
+	_, subs := mockProducer(7, limiter)
+
+	// A full bucket serves `Burst` takes at once, then takes are paced at 50ms
+	assert.Less(t, subs[4], 10*time.Millisecond)
+	assert.Greater(t, subs[5], 45*time.Millisecond)
+	assert.Greater(t, subs[6], 95*time.Millisecond)
+	assertBurstBound(t, subs, 20, 5)
+
+	// Idle periods refill the bucket, but never beyond `Burst`
+	time.Sleep(500 * time.Millisecond)
+	_, subs = mockProducer(6, limiter)
+	limiter.Close()
+
+	assert.Less(t, subs[4], 10*time.Millisecond)
+	assert.Greater(t, subs[5], 45*time.Millisecond)
+}
+
+func TestTokenBucketMaxTakes(t *testing.T) {
+	limiter := NewLimiter(LimiterConfig{Rate: 1000, Burst: 2, MaxTakes: 4})
+	numbers, _ := mockProducer(10, limiter)
+
+	assert.Equal(t, []int{0, 1, 2, 3}, numbers)
+	assert.Equal(t, false, limiter.Take(context.Background()))
+}
+
+func TestTokenBucketClose(t *testing.T) {
+	limiter := NewLimiter(LimiterConfig{Rate: 0.1, MaxTakes: 10})
+	assert.Equal(t, true, limiter.Take(context.Background()))
+
+	// Takes waiting for a token are released, following ones are refused
+	released := make(chan bool)
+
+	go func() { released <- limiter.Take(context.Background()) }()
+
+	time.Sleep(10 * time.Millisecond)
+	limiter.Close()
+	limiter.Close()
+
+	assert.Equal(t, false, <-released)
+	assert.Equal(t, false, limiter.Take(context.Background()))
+}
+
+/* Private stuffs */
+
+// assertBurstBound checks that no window of takes exceeds what the bucket allows: `burst` plus the refill
+func assertBurstBound(t *testing.T, subs []time.Duration, rate float64, burst int) {
+	tolerance := 2 * time.Millisecond
+
+	for first := range subs {
+		for last := first; last < len(subs); last++ {
+			window := (subs[last] - subs[first] + tolerance).Seconds()
+			allowed := float64(burst) + rate*window
+
+			assert.LessOrEqual(t, float64(last-first+1), allowed, "takes %d to %d", first, last)
+		}
+	}
+}
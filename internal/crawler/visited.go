@@ -0,0 +1,46 @@
+package crawler
+
+import "sync"
+
+// VisitedSet keeps track of profiles which were already scheduled for crawling,
+// so that every profile is crawled at most once per run
+type VisitedSet interface {
+	// Visit marks `key` as visited and reports whether it wasn't visited before
+	Visit(key string) (bool, error)
+}
+
+// NewMemoryVisitedSet creates an in-memory VisitedSet, safe for concurrent use
+func NewMemoryVisitedSet() VisitedSet {
+	return &memoryVisitedSet{
+		keys: map[string]struct{}{},
+	}
+}
+
+// Visit marks `key` as visited and reports whether it wasn't visited before
+func (v *memoryVisitedSet) Visit(key string) (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, ok := v.keys[key]; ok {
+		return false, nil
+	}
+
+	v.keys[key] = struct{}{}
+	return true, nil
+}
+
+/* Private stuffs */
+
+type memoryVisitedSet struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// visitedKey identifies a profile by its ID, or by its username if the ID is unknown
+func visitedKey(profile Profile) string {
+	if profile.ID != "" {
+		return "id:" + profile.ID
+	}
+
+	return "username:" + profile.Username
+}
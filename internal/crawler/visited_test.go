@@ -0,0 +1,53 @@
+package crawler
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryVisitedSet(t *testing.T) {
+	visited := NewMemoryVisitedSet()
+
+	ok, err := visited.Visit("id:1")
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+
+	ok, err = visited.Visit("id:1")
+	assert.Equal(t, nil, err)
+	assert.False(t, ok)
+
+	ok, _ = visited.Visit("id:2")
+	assert.True(t, ok)
+}
+
+func TestMemoryVisitedSetConcurrency(t *testing.T) {
+	visited := NewMemoryVisitedSet()
+	wg := &sync.WaitGroup{}
+	var newVisits uint32
+
+	for worker := 0; worker < 10; worker++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for idx := 0; idx < 100; idx++ {
+				if ok, _ := visited.Visit(fmt.Sprint(idx)); ok {
+					atomic.AddUint32(&newVisits, 1)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, uint32(100), newVisits)
+}
+
+func TestVisitedKey(t *testing.T) {
+	assert.Equal(t, "id:1234", visitedKey(Profile{ID: "1234", Username: "fake.user"}))
+	assert.Equal(t, "username:fake.user", visitedKey(Profile{Username: "fake.user"}))
+}
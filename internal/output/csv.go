@@ -0,0 +1,217 @@
+package output
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"nsfw/internal/crawler"
+	"strconv"
+	"strings"
+)
+
+// Columns of the CSV outputs, in order
+var (
+	CSVColumns        = []string{"source", "id", "username", "display_name", "avatar_url", "depth", "gallery"}
+	CSVGalleryColumns = []string{"profile_id", "position", "url"}
+)
+
+// CSVConfig holds configurations for a CSVWriter
+// @param Delimiter: field delimiter, `,` if zero
+// @param GallerySeparator: separator of gallery URLs within the gallery column, `|` if empty
+// @param Gallery: if set, gallery URLs are written to this separate CSV keyed by profile ID, and the gallery column is left empty
+// @param SkipHeader: doesn't write the header row of the profiles, e.g. when appending to an existing output
+// @param SkipGalleryHeader: doesn't write the header row of `Gallery`, e.g. when appending to an existing gallery
+type CSVConfig struct {
+	Delimiter         rune
+	GallerySeparator  string
+	Gallery           io.Writer
+	SkipHeader        bool
+	SkipGalleryHeader bool
+}
+
+// NewCSVWriter creates a crawler.Writer emitting one row per profile, with a header row and fixed columns
+func NewCSVWriter(w io.Writer, config CSVConfig) *CSVWriter {
+	if config.Delimiter == 0 {
+		config.Delimiter = ','
+	}
+
+	if config.GallerySeparator == "" {
+		config.GallerySeparator = "|"
+	}
+
+	writer := &CSVWriter{
+		w:                    w,
+		config:               config,
+		writer:               newCSV(w, config.Delimiter),
+		headerWritten:        config.SkipHeader,
+		galleryHeaderWritten: config.SkipGalleryHeader,
+	}
+
+	if config.Gallery != nil {
+		writer.galleryWriter = newCSV(config.Gallery, config.Delimiter)
+	}
+
+	return writer
+}
+
+// CSVWriter writes profiles as CSV rows, see CSVColumns and CSVGalleryColumns
+type CSVWriter struct {
+	w                    io.Writer
+	config               CSVConfig
+	writer               *csv.Writer
+	galleryWriter        *csv.Writer
+	headerWritten        bool
+	galleryHeaderWritten bool
+}
+
+// Write appends a row for `profile`, writing the header rows first if needed.
+// Rows are buffered, errors of the underlying writer are reported as soon as they are detected.
+func (w *CSVWriter) Write(profile crawler.Profile) error {
+	if err := w.writeHeaders(); err != nil {
+		return err
+	}
+
+	gallery := strings.Join(profile.Gallery, w.config.GallerySeparator)
+
+	if w.galleryWriter != nil {
+		gallery = ""
+	}
+
+	row := []string{
+		profile.Source,
+		profile.ID,
+		profile.Username,
+		profile.DisplayName,
+		profile.AvatarURL,
+		strconv.Itoa(profile.Depth),
+		gallery,
+	}
+
+	// The profile row goes first, so that gallery rows never refer to a profile missing from the output
+	if err := w.writer.Write(row); err != nil {
+		return err
+	}
+
+	if w.galleryWriter != nil {
+		for position, url := range profile.Gallery {
+			if err := w.galleryWriter.Write([]string{profile.ID, strconv.Itoa(position), url}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return w.err()
+}
+
+// Flush writes buffered rows, and returns any error which occurred while writing
+func (w *CSVWriter) Flush() error {
+	w.writer.Flush()
+
+	if w.galleryWriter != nil {
+		w.galleryWriter.Flush()
+	}
+
+	return w.err()
+}
+
+// Close flushes buffered rows, then closes the underlying writers which are io.Closers
+func (w *CSVWriter) Close() error {
+	err := w.Flush()
+
+	if closeErr := closeIfCloser(w.config.Gallery); err == nil {
+		err = closeErr
+	}
+
+	if closeErr := closeIfCloser(w.w); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// ReadCSVIDs returns the IDs of the profiles in a CSV output written by a CSVWriter with `delimiter`, `,` if zero.
+// Rows missing columns, e.g. partially written by a crash, are skipped so that their profiles are written again.
+func ReadCSVIDs(r io.Reader, delimiter rune) (map[string]struct{}, error) {
+	if delimiter == 0 {
+		delimiter = ','
+	}
+
+	reader := csv.NewReader(r)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+
+	ids := map[string]struct{}{}
+	header := strings.Join(CSVColumns, ",")
+
+	for {
+		row, err := reader.Read()
+
+		if err == io.EOF {
+			return ids, nil
+		}
+
+		if err != nil && !isCSVParseError(err) {
+			return nil, err
+		}
+
+		// Neither partial rows nor the header row are profiles
+		if err != nil || len(row) != len(CSVColumns) || strings.Join(row, ",") == header {
+			continue
+		}
+
+		ids[row[1]] = struct{}{}
+	}
+}
+
+/* Private stuffs */
+
+var (
+	_ crawler.Writer  = (*CSVWriter)(nil)
+	_ crawler.Flusher = (*CSVWriter)(nil)
+)
+
+func newCSV(w io.Writer, delimiter rune) *csv.Writer {
+	writer := csv.NewWriter(w)
+	writer.Comma = delimiter
+
+	return writer
+}
+
+// writeHeaders writes the header rows not written yet, each output may already have its own
+func (w *CSVWriter) writeHeaders() error {
+	if !w.headerWritten {
+		if err := w.writer.Write(CSVColumns); err != nil {
+			return err
+		}
+
+		w.headerWritten = true
+	}
+
+	if w.galleryWriter != nil && !w.galleryHeaderWritten {
+		if err := w.galleryWriter.Write(CSVGalleryColumns); err != nil {
+			return err
+		}
+
+		w.galleryHeaderWritten = true
+	}
+
+	return nil
+}
+
+func isCSVParseError(err error) bool {
+	parseErr := &csv.ParseError{}
+	return errors.As(err, &parseErr)
+}
+
+// err returns the first error of the underlying csv.Writers, which are sticky once a flush failed
+func (w *CSVWriter) err() error {
+	if err := w.writer.Error(); err != nil {
+		return err
+	}
+
+	if w.galleryWriter != nil {
+		return w.galleryWriter.Error()
+	}
+
+	return nil
+}
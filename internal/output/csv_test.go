@@ -0,0 +1,138 @@
+package output
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"nsfw/internal/crawler"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSVWriter(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writer := NewCSVWriter(buffer, CSVConfig{})
+
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.Write(crawler.Profile{ID: "2", DisplayName: "User, \"Two\""}))
+	assert.Equal(t, nil, writer.Flush())
+
+	expected := `source,id,username,display_name,avatar_url,depth,gallery
+Instagram,1,user_1,User 1,https://fake-avatar,2,https://fake-photo-1|https://fake-photo-2
+,2,,"User, ""Two""",,0,
+`
+	assert.Equal(t, expected, buffer.String())
+
+	// Every row has the same amount of columns, whatever the size of the gallery
+	rows, err := csv.NewReader(buffer).ReadAll()
+	assert.Equal(t, nil, err)
+
+	for _, row := range rows {
+		assert.Equal(t, len(CSVColumns), len(row))
+	}
+}
+
+func TestCSVWriterConfig(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	gallery := &bytes.Buffer{}
+	writer := NewCSVWriter(buffer, CSVConfig{
+		Delimiter: ';',
+		Gallery:   gallery,
+	})
+
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.Flush())
+
+	assert.Equal(t, "source;id;username;display_name;avatar_url;depth;gallery\nInstagram;1;user_1;User 1;https://fake-avatar;2;\n", buffer.String())
+	assert.Equal(t, "profile_id;position;url\n1;0;https://fake-photo-1\n1;1;https://fake-photo-2\n", gallery.String())
+
+	buffer.Reset()
+	writer = NewCSVWriter(buffer, CSVConfig{GallerySeparator: " ", SkipHeader: true})
+
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.Flush())
+	assert.Equal(t, "Instagram,1,user_1,User 1,https://fake-avatar,2,https://fake-photo-1 https://fake-photo-2\n", buffer.String())
+
+	// Each output skips its own header, e.g. when only the profiles are appended to an existing file
+	buffer.Reset()
+	gallery.Reset()
+	writer = NewCSVWriter(buffer, CSVConfig{Gallery: gallery, SkipHeader: true})
+
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.Flush())
+	assert.Equal(t, "Instagram,1,user_1,User 1,https://fake-avatar,2,\n", buffer.String())
+	assert.Equal(t, "profile_id,position,url\n1,0,https://fake-photo-1\n1,1,https://fake-photo-2\n", gallery.String())
+
+	buffer.Reset()
+	gallery.Reset()
+	writer = NewCSVWriter(buffer, CSVConfig{Gallery: gallery, SkipGalleryHeader: true})
+
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.Flush())
+	assert.Equal(t, "source,id,username,display_name,avatar_url,depth,gallery\nInstagram,1,user_1,User 1,https://fake-avatar,2,\n", buffer.String())
+	assert.Equal(t, "1,0,https://fake-photo-1\n1,1,https://fake-photo-2\n", gallery.String())
+}
+
+func TestCSVWriterClose(t *testing.T) {
+	file, gallery := &mockFile{}, &mockFile{}
+	writer := NewCSVWriter(file, CSVConfig{Gallery: gallery})
+
+	// Buffered rows are flushed before the files are closed
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.Close())
+	assert.Equal(t, 2, strings.Count(file.String(), "\n"))
+	assert.Equal(t, 3, strings.Count(gallery.String(), "\n"))
+	assert.Equal(t, true, file.closed)
+	assert.Equal(t, true, gallery.closed)
+}
+
+func TestReadCSVIDs(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writer := NewCSVWriter(buffer, CSVConfig{Delimiter: ';'})
+
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.Write(crawler.Profile{ID: "2", DisplayName: "User; \"Two\""}))
+	assert.Equal(t, nil, writer.Flush())
+
+	// The last row was cut short by a crash
+	buffer.WriteString("Instagram;3;user_3;Us")
+
+	ids, err := ReadCSVIDs(buffer, ';')
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]struct{}{"1": {}, "2": {}}, ids)
+
+	ids, err = ReadCSVIDs(strings.NewReader(""), 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]struct{}{}, ids)
+}
+
+func TestCSVWriterErrors(t *testing.T) {
+	w := &mockFile{err: errors.New("disk full")}
+	writer := NewCSVWriter(w, CSVConfig{})
+
+	// Rows are buffered, the error shows up on flush
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.EqualError(t, writer.Flush(), "disk full")
+
+	// Then on every following write
+	assert.EqualError(t, writer.Write(fakeProfile), "disk full")
+
+	// Rows larger than the buffer fail right away
+	writer = NewCSVWriter(w, CSVConfig{})
+	profile := fakeProfile
+	profile.DisplayName = strings.Repeat("a", 8192)
+	assert.EqualError(t, writer.Write(profile), "disk full")
+
+	// The gallery of a profile which failed to be written is left out
+	gallery := &bytes.Buffer{}
+	writer = NewCSVWriter(w, CSVConfig{Gallery: gallery})
+	assert.EqualError(t, writer.Write(profile), "disk full")
+
+	writer.galleryWriter.Flush()
+	assert.Equal(t, "profile_id,position,url\n", gallery.String())
+
+	writer = NewCSVWriter(&bytes.Buffer{}, CSVConfig{Delimiter: '\n'})
+	assert.NotEqual(t, nil, writer.Write(fakeProfile))
+}
@@ -0,0 +1,324 @@
+package output
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"nsfw/internal/crawler"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GraphFormat is a file format of the suggestion graph
+type GraphFormat string
+
+// Supported graph formats
+const (
+	GraphML GraphFormat = "graphml"
+	GEXF    GraphFormat = "gexf"
+	DOT     GraphFormat = "dot"
+)
+
+// GraphFormatOf returns the graph format matching the extension of `path`, if any
+func GraphFormatOf(path string) (GraphFormat, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".graphml":
+		return GraphML, true
+	case ".gexf":
+		return GEXF, true
+	case ".dot", ".gv":
+		return DOT, true
+	}
+
+	return "", false
+}
+
+// NewGraphWriter creates a crawler.Writer collecting the suggestion graph: crawled profiles and the profiles
+// they suggested are nodes, suggestions are directed edges. Since these formats are whole documents,
+// the graph is kept in memory and written to `w` on Flush, e.g. to visualize it with Gephi or Graphviz.
+func NewGraphWriter(w io.Writer, format GraphFormat) (*GraphWriter, error) {
+	switch format {
+	case GraphML, GEXF, DOT:
+	default:
+		return nil, fmt.Errorf("unsupported graph format %q", format)
+	}
+
+	return &GraphWriter{
+		w:      w,
+		format: format,
+		nodes:  map[string]*graphNode{},
+		edges:  map[[2]string]bool{},
+	}, nil
+}
+
+// GraphWriter writes the suggestion graph as GraphML, GEXF or DOT
+type GraphWriter struct {
+	// Received configurations
+	w      io.Writer
+	format GraphFormat
+
+	// mu: guards the graph, nodes and edges keep their discovery order
+	mu        sync.Mutex
+	nodes     map[string]*graphNode
+	nodeOrder []string
+	edges     map[[2]string]bool
+	edgeOrder []graphEdge
+}
+
+// Write adds a crawled profile as a node
+func (w *GraphWriter) Write(profile crawler.Profile) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	node := w.node(profile)
+	node.profile = profile
+	node.crawled = true
+
+	return nil
+}
+
+// WriteEdge adds the suggestion of `edge.To` by `edge.From`, edges already added keep their first discovery
+func (w *GraphWriter) WriteEdge(edge crawler.Edge) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	from, to := w.node(edge.From), w.node(edge.To)
+	key := [2]string{from.id, to.id}
+
+	if w.edges[key] {
+		return nil
+	}
+
+	w.edges[key] = true
+	w.edgeOrder = append(w.edgeOrder, graphEdge{from: from.id, to: to.id, depth: edge.Depth, timestamp: edgeTimestamp(edge)})
+
+	return nil
+}
+
+// Flush writes the whole graph collected so far. If `w` is a file, it is rewritten from the start,
+// so that flushing many times leaves a single valid document.
+func (w *GraphWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if file, ok := w.w.(truncater); ok {
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	buffer := bufio.NewWriter(w.w)
+	var err error
+
+	switch w.format {
+	case GraphML:
+		err = w.writeGraphML(buffer)
+	case GEXF:
+		err = w.writeGEXF(buffer)
+	case DOT:
+		err = w.writeDOT(buffer)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return buffer.Flush()
+}
+
+// Close writes the whole graph, then closes the underlying writer if it is an io.Closer
+func (w *GraphWriter) Close() error {
+	err := w.Flush()
+
+	if closeErr := closeIfCloser(w.w); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+/* Private stuffs */
+
+var (
+	_ crawler.Writer     = (*GraphWriter)(nil)
+	_ crawler.EdgeWriter = (*GraphWriter)(nil)
+	_ crawler.Flusher    = (*GraphWriter)(nil)
+)
+
+// truncater is implemented by *os.File
+type truncater interface {
+	io.Seeker
+	Truncate(size int64) error
+}
+
+type graphNode struct {
+	id      string
+	profile crawler.Profile
+	crawled bool
+}
+
+type graphEdge struct {
+	from      string
+	to        string
+	depth     int
+	timestamp time.Time
+}
+
+// node returns the node of `profile`, added if needed. Nodes are identified by profile ID, or username if unknown.
+func (w *GraphWriter) node(profile crawler.Profile) *graphNode {
+	id := profile.ID
+
+	if id == "" {
+		id = profile.Username
+	}
+
+	node, ok := w.nodes[id]
+
+	if !ok {
+		node = &graphNode{id: id, profile: profile}
+		w.nodes[id] = node
+		w.nodeOrder = append(w.nodeOrder, id)
+	}
+
+	return node
+}
+
+func (w *GraphWriter) writeGraphML(out io.Writer) error {
+	fmt.Fprint(out, xml.Header)
+	fmt.Fprintln(out, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	fmt.Fprintln(out, `  <key id="username" for="node" attr.name="username" attr.type="string"/>`)
+	fmt.Fprintln(out, `  <key id="display_name" for="node" attr.name="display_name" attr.type="string"/>`)
+	fmt.Fprintln(out, `  <key id="node_depth" for="node" attr.name="depth" attr.type="int"/>`)
+	fmt.Fprintln(out, `  <key id="crawled" for="node" attr.name="crawled" attr.type="boolean"/>`)
+	fmt.Fprintln(out, `  <key id="edge_depth" for="edge" attr.name="depth" attr.type="int"/>`)
+	fmt.Fprintln(out, `  <key id="timestamp" for="edge" attr.name="timestamp" attr.type="string"/>`)
+	fmt.Fprintln(out, `  <graph id="suggestions" edgedefault="directed">`)
+
+	for _, id := range w.nodeOrder {
+		node := w.nodes[id]
+
+		fmt.Fprintf(out, "    <node id=\"%s\">\n", escapeXML(node.id))
+		fmt.Fprintf(out, "      <data key=\"username\">%s</data>\n", escapeXML(node.profile.Username))
+
+		if node.crawled {
+			fmt.Fprintf(out, "      <data key=\"display_name\">%s</data>\n", escapeXML(node.profile.DisplayName))
+			fmt.Fprintf(out, "      <data key=\"node_depth\">%d</data>\n", node.profile.Depth)
+		}
+
+		fmt.Fprintf(out, "      <data key=\"crawled\">%t</data>\n", node.crawled)
+		fmt.Fprintln(out, "    </node>")
+	}
+
+	for i, edge := range w.edgeOrder {
+		fmt.Fprintf(out, "    <edge id=\"e%d\" source=\"%s\" target=\"%s\">\n", i, escapeXML(edge.from), escapeXML(edge.to))
+		fmt.Fprintf(out, "      <data key=\"edge_depth\">%d</data>\n", edge.depth)
+		fmt.Fprintf(out, "      <data key=\"timestamp\">%s</data>\n", edge.timestamp.Format(time.RFC3339))
+		fmt.Fprintln(out, "    </edge>")
+	}
+
+	fmt.Fprintln(out, "  </graph>")
+	_, err := fmt.Fprintln(out, "</graphml>")
+
+	return err
+}
+
+func (w *GraphWriter) writeGEXF(out io.Writer) error {
+	fmt.Fprint(out, xml.Header)
+	fmt.Fprintln(out, `<gexf xmlns="http://gexf.net/1.3" version="1.3">`)
+	fmt.Fprintln(out, `  <graph defaultedgetype="directed">`)
+	fmt.Fprintln(out, `    <attributes class="node">`)
+	fmt.Fprintln(out, `      <attribute id="0" title="username" type="string"/>`)
+	fmt.Fprintln(out, `      <attribute id="1" title="display_name" type="string"/>`)
+	fmt.Fprintln(out, `      <attribute id="2" title="depth" type="integer"/>`)
+	fmt.Fprintln(out, `      <attribute id="3" title="crawled" type="boolean"/>`)
+	fmt.Fprintln(out, `    </attributes>`)
+	fmt.Fprintln(out, `    <attributes class="edge">`)
+	fmt.Fprintln(out, `      <attribute id="0" title="depth" type="integer"/>`)
+	fmt.Fprintln(out, `      <attribute id="1" title="timestamp" type="string"/>`)
+	fmt.Fprintln(out, `    </attributes>`)
+	fmt.Fprintln(out, `    <nodes>`)
+
+	for _, id := range w.nodeOrder {
+		node := w.nodes[id]
+
+		fmt.Fprintf(out, "      <node id=\"%s\" label=\"%s\">\n", escapeXML(node.id), escapeXML(node.profile.Username))
+		fmt.Fprintln(out, "        <attvalues>")
+		fmt.Fprintf(out, "          <attvalue for=\"0\" value=\"%s\"/>\n", escapeXML(node.profile.Username))
+
+		if node.crawled {
+			fmt.Fprintf(out, "          <attvalue for=\"1\" value=\"%s\"/>\n", escapeXML(node.profile.DisplayName))
+			fmt.Fprintf(out, "          <attvalue for=\"2\" value=\"%d\"/>\n", node.profile.Depth)
+		}
+
+		fmt.Fprintf(out, "          <attvalue for=\"3\" value=\"%t\"/>\n", node.crawled)
+		fmt.Fprintln(out, "        </attvalues>")
+		fmt.Fprintln(out, "      </node>")
+	}
+
+	fmt.Fprintln(out, `    </nodes>`)
+	fmt.Fprintln(out, `    <edges>`)
+
+	for i, edge := range w.edgeOrder {
+		fmt.Fprintf(out, "      <edge id=\"%d\" source=\"%s\" target=\"%s\">\n", i, escapeXML(edge.from), escapeXML(edge.to))
+		fmt.Fprintln(out, "        <attvalues>")
+		fmt.Fprintf(out, "          <attvalue for=\"0\" value=\"%d\"/>\n", edge.depth)
+		fmt.Fprintf(out, "          <attvalue for=\"1\" value=\"%s\"/>\n", edge.timestamp.Format(time.RFC3339))
+		fmt.Fprintln(out, "        </attvalues>")
+		fmt.Fprintln(out, "      </edge>")
+	}
+
+	fmt.Fprintln(out, `    </edges>`)
+	fmt.Fprintln(out, `  </graph>`)
+	_, err := fmt.Fprintln(out, `</gexf>`)
+
+	return err
+}
+
+func (w *GraphWriter) writeDOT(out io.Writer) error {
+	fmt.Fprintln(out, "digraph suggestions {")
+
+	for _, id := range w.nodeOrder {
+		node := w.nodes[id]
+		attributes := []string{"label=" + quoteDOT(node.profile.Username)}
+
+		if node.crawled {
+			attributes = append(attributes,
+				"display_name="+quoteDOT(node.profile.DisplayName),
+				"depth="+strconv.Itoa(node.profile.Depth),
+			)
+		} else {
+			// Suggested profiles which weren't crawled stand out
+			attributes = append(attributes, "style=dashed")
+		}
+
+		fmt.Fprintf(out, "  %s [%s];\n", quoteDOT(node.id), strings.Join(attributes, ", "))
+	}
+
+	for _, edge := range w.edgeOrder {
+		fmt.Fprintf(out, "  %s -> %s [depth=%d, timestamp=%s];\n",
+			quoteDOT(edge.from), quoteDOT(edge.to), edge.depth, quoteDOT(edge.timestamp.Format(time.RFC3339)))
+	}
+
+	_, err := fmt.Fprintln(out, "}")
+
+	return err
+}
+
+func escapeXML(value string) string {
+	var builder strings.Builder
+	xml.EscapeText(&builder, []byte(value))
+
+	return builder.String()
+}
+
+func quoteDOT(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
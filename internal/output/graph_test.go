@@ -0,0 +1,107 @@
+package output
+
+import (
+	"bytes"
+	"encoding/xml"
+	"nsfw/internal/crawler"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphFormatOf(t *testing.T) {
+	for path, expected := range map[string]GraphFormat{
+		"graph.graphml": GraphML,
+		"graph.GEXF":    GEXF,
+		"graph.dot":     DOT,
+		"graph.gv":      DOT,
+	} {
+		format, ok := GraphFormatOf(path)
+		assert.Equal(t, true, ok, path)
+		assert.Equal(t, expected, format, path)
+	}
+
+	_, ok := GraphFormatOf("results.csv")
+	assert.Equal(t, false, ok)
+
+	_, err := NewGraphWriter(&bytes.Buffer{}, "svg")
+	assert.EqualError(t, err, `unsupported graph format "svg"`)
+}
+
+func TestGraphWriterDOT(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writer, _ := NewGraphWriter(buffer, DOT)
+	writeFakeGraph(t, writer)
+
+	assert.Equal(t, nil, writer.Flush())
+	assert.Equal(t, `digraph suggestions {
+  "1" [label="user_1", display_name="User 1", depth=2];
+  "2" [label="user_\"2\"", style=dashed];
+  "1" -> "2" [depth=2, timestamp="2022-01-02T03:04:05Z"];
+}
+`, buffer.String())
+}
+
+func TestGraphWriterXML(t *testing.T) {
+	for _, format := range []GraphFormat{GraphML, GEXF} {
+		buffer := &bytes.Buffer{}
+		writer, _ := NewGraphWriter(buffer, format)
+		writeFakeGraph(t, writer)
+
+		assert.Equal(t, nil, writer.Flush())
+		document := buffer.String()
+
+		// Documents are well-formed, with special characters escaped
+		decoder := xml.NewDecoder(strings.NewReader(document))
+
+		for {
+			_, err := decoder.Token()
+
+			if err != nil {
+				assert.Equal(t, "EOF", err.Error(), format)
+				break
+			}
+		}
+
+		assert.Contains(t, document, `user_&#34;2&#34;`, format)
+		assert.Contains(t, document, `2022-01-02T03:04:05Z`, format)
+		assert.Equal(t, 1, strings.Count(document, `source="1" target="2"`), format)
+	}
+}
+
+func TestGraphWriterRewritesFile(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "graph.dot"))
+	assert.Equal(t, nil, err)
+
+	writer, _ := NewGraphWriter(file, DOT)
+	writeFakeGraph(t, writer)
+
+	assert.Equal(t, nil, writer.Flush())
+	assert.Equal(t, nil, writer.Close())
+
+	content, _ := os.ReadFile(file.Name())
+	assert.Equal(t, 1, strings.Count(string(content), "digraph"))
+
+	// The file is closed along with the writer
+	assert.NotEqual(t, nil, file.Close())
+}
+
+/* Private stuffs */
+
+// writeFakeGraph writes `fakeProfile` which suggested a profile not crawled, twice
+func writeFakeGraph(t *testing.T, writer *GraphWriter) {
+	edge := crawler.Edge{
+		From:      fakeProfile,
+		To:        crawler.Profile{ID: "2", Username: `user_"2"`},
+		Depth:     fakeProfile.Depth,
+		Timestamp: time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.WriteEdge(edge))
+	assert.Equal(t, nil, writer.WriteEdge(edge))
+}
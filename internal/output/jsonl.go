@@ -0,0 +1,112 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"nsfw/internal/crawler"
+)
+
+// NewJSONLinesWriter creates a crawler.Writer emitting one JSON object per profile and per line.
+// Every line is written to `w` at once, so that the output can be tailed while crawling.
+func NewJSONLinesWriter(w io.Writer) *JSONLinesWriter {
+	return &JSONLinesWriter{w: w}
+}
+
+// JSONLinesWriter writes profiles in the JSON Lines format, see https://jsonlines.org
+type JSONLinesWriter struct {
+	w io.Writer
+}
+
+// Write encodes all fields of `profile` as a single line
+func (w *JSONLinesWriter) Write(profile crawler.Profile) error {
+	line, err := json.Marshal(newProfileRecord(profile))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.w.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the underlying writer if it is an io.Closer, e.g. the output file
+func (w *JSONLinesWriter) Close() error {
+	return closeIfCloser(w.w)
+}
+
+// ReadJSONLinesIDs returns the IDs of the profiles in a JSON Lines output written by a JSONLinesWriter.
+// Lines partially written by a crash are skipped, so that their profiles are written again.
+func ReadJSONLinesIDs(r io.Reader) (map[string]struct{}, error) {
+	ids := map[string]struct{}{}
+	scanner := bufio.NewScanner(r)
+
+	// Lines are as long as the gallery of their profile
+	scanner.Buffer(nil, 16*1024*1024)
+
+	for scanner.Scan() {
+		record := profileRecord{}
+
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			syntaxErr := &json.SyntaxError{}
+
+			if errors.As(err, &syntaxErr) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		if record.ID != "" {
+			ids[record.ID] = struct{}{}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+/* Private stuffs */
+
+var _ crawler.Writer = (*JSONLinesWriter)(nil)
+
+func closeIfCloser(w io.Writer) error {
+	if closer, ok := w.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// profileRecord is the serialized form of a crawler.Profile, shared by all writers
+type profileRecord struct {
+	Source      string   `json:"source"`
+	ID          string   `json:"id"`
+	Username    string   `json:"username"`
+	DisplayName string   `json:"display_name"`
+	AvatarURL   string   `json:"avatar_url"`
+	Depth       int      `json:"depth"`
+	Gallery     []string `json:"gallery"`
+}
+
+func newProfileRecord(profile crawler.Profile) profileRecord {
+	gallery := profile.Gallery
+
+	if gallery == nil {
+		gallery = []string{}
+	}
+
+	return profileRecord{
+		Source:      profile.Source,
+		ID:          profile.ID,
+		Username:    profile.Username,
+		DisplayName: profile.DisplayName,
+		AvatarURL:   profile.AvatarURL,
+		Depth:       profile.Depth,
+		Gallery:     gallery,
+	}
+}
@@ -0,0 +1,121 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"nsfw/internal/crawler"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONLinesWriter(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writer := NewJSONLinesWriter(buffer)
+
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.Write(crawler.Profile{ID: "2", Username: "user_2"}))
+
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.JSONEq(t, `{
+		"source": "Instagram",
+		"id": "1",
+		"username": "user_1",
+		"display_name": "User 1",
+		"avatar_url": "https://fake-avatar",
+		"depth": 2,
+		"gallery": ["https://fake-photo-1", "https://fake-photo-2"]
+	}`, lines[0])
+	assert.JSONEq(t, `{
+		"source": "",
+		"id": "2",
+		"username": "user_2",
+		"display_name": "",
+		"avatar_url": "",
+		"depth": 0,
+		"gallery": []
+	}`, lines[1])
+
+	// Lines are parsable one by one, as done by streaming consumers
+	scanner := bufio.NewScanner(strings.NewReader(buffer.String()))
+	records := []profileRecord{}
+
+	for scanner.Scan() {
+		record := profileRecord{}
+		assert.Equal(t, nil, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+
+	assert.Equal(t, newProfileRecord(fakeProfile), records[0])
+}
+
+func TestJSONLinesWriterFlushesEveryLine(t *testing.T) {
+	w := &mockFile{}
+	writer := NewJSONLinesWriter(w)
+
+	_ = writer.Write(fakeProfile)
+	_ = writer.Write(fakeProfile)
+	assert.Equal(t, 2, w.writes)
+
+	w.err = errors.New("disk full")
+	assert.EqualError(t, writer.Write(fakeProfile), "disk full")
+
+	assert.Equal(t, nil, writer.Close())
+	assert.Equal(t, true, w.closed)
+}
+
+func TestReadJSONLinesIDs(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writer := NewJSONLinesWriter(buffer)
+
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.Write(crawler.Profile{ID: "2", Username: "user_2"}))
+
+	// The last line was cut short by a crash
+	buffer.WriteString(`{"source":"Instagram","id":"3","userna`)
+
+	ids, err := ReadJSONLinesIDs(buffer)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]struct{}{"1": {}, "2": {}}, ids)
+
+	_, err = ReadJSONLinesIDs(strings.NewReader(`{"id": 1}`))
+	assert.NotEqual(t, nil, err)
+}
+
+/* Private stuffs */
+
+var fakeProfile = crawler.Profile{
+	Source:      "Instagram",
+	AvatarURL:   "https://fake-avatar",
+	Depth:       2,
+	DisplayName: "User 1",
+	Gallery:     []string{"https://fake-photo-1", "https://fake-photo-2"},
+	ID:          "1",
+	Username:    "user_1",
+}
+
+// mockFile counts writes, and fails them with `err` if set
+type mockFile struct {
+	bytes.Buffer
+	writes int
+	err    error
+	closed bool
+}
+
+func (f *mockFile) Close() error {
+	f.closed = true
+	return nil
+}
+
+func (f *mockFile) Write(p []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+
+	f.writes++
+	return f.Buffer.Write(p)
+}
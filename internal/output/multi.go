@@ -0,0 +1,303 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"nsfw/internal/crawler"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrorPolicy decides how a MultiWriter reacts to the errors of one of its sinks
+type ErrorPolicy string
+
+// Supported error policies
+const (
+	// FailFast: the error is fatal, the crawl is aborted and no sink is written anymore
+	FailFast ErrorPolicy = "fail-fast"
+	// BestEffort: the error is logged and ignored, e.g. for a secondary output
+	BestEffort ErrorPolicy = "best-effort"
+	// Retry: the write is retried with a backoff, then the error is reported without aborting the crawl
+	Retry ErrorPolicy = "retry"
+)
+
+// ParseErrorPolicy converts `fail-fast`, `best-effort` or `retry` into an ErrorPolicy
+func ParseErrorPolicy(value string) (ErrorPolicy, error) {
+	for _, policy := range []ErrorPolicy{FailFast, BestEffort, Retry} {
+		if value == string(policy) {
+			return policy, nil
+		}
+	}
+
+	return FailFast, fmt.Errorf("unknown error policy %q, expected fail-fast, best-effort or retry", value)
+}
+
+// Sink is one of the writers of a MultiWriter
+// @param Name: identifies the sink in errors and logs, e.g. its path
+// @param Writer: writes the profiles, and the edges if it is a crawler.EdgeWriter
+// @param Policy: reaction to the errors of `Writer`, FailFast if empty
+// @param MaxAttempts: total attempts of the Retry policy, 3 if zero
+// @param RetryDelay: delay before the first retry of the Retry policy, doubled on every following retry, 100ms if zero
+// @param Written: IDs of the profiles already in the output, which aren't written again, e.g. when a resumed crawl appends to it
+type Sink struct {
+	Name        string
+	Writer      crawler.Writer
+	Policy      ErrorPolicy
+	MaxAttempts int
+	RetryDelay  time.Duration
+	Written     map[string]struct{}
+}
+
+// SinkError is a failed write of a MultiWriter sink.
+// It matches crawler.ErrFatalWrite with `errors.Is` if the sink fails fast, so that the crawl is aborted.
+type SinkError struct {
+	Sink   string
+	Policy ErrorPolicy
+	Err    error
+}
+
+func (e *SinkError) Error() string {
+	return fmt.Sprintf("sink %s: %v", e.Sink, e.Err)
+}
+
+// Unwrap returns the error of the sink
+func (e *SinkError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches crawler.ErrFatalWrite according to the policy of the sink
+func (e *SinkError) Is(target error) bool {
+	return target == crawler.ErrFatalWrite && e.Policy == FailFast
+}
+
+// NewMultiWriter creates a crawler.Writer fanning out every profile and edge to all `sinks`, in order.
+// Edges are only written to sinks which are crawler.EdgeWriters.
+func NewMultiWriter(sinks ...Sink) *MultiWriter {
+	for i := range sinks {
+		if sinks[i].Policy == "" {
+			sinks[i].Policy = FailFast
+		}
+
+		if sinks[i].MaxAttempts == 0 {
+			sinks[i].MaxAttempts = 3
+		}
+
+		if sinks[i].RetryDelay == 0 {
+			sinks[i].RetryDelay = 100 * time.Millisecond
+		}
+	}
+
+	return &MultiWriter{sinks: sinks}
+}
+
+// MultiWriter writes to several sinks, each one with its own ErrorPolicy
+type MultiWriter struct {
+	sinks []Sink
+
+	// mu: guards `fatalErr`
+	// fatalErr: the error of a failing fast sink, returned by every following write
+	mu       sync.Mutex
+	fatalErr error
+}
+
+// Write writes `profile` to all sinks, except the ones which already have it.
+// It returns the error of the first sink failing fast, or else of the first sink failing its retries.
+func (w *MultiWriter) Write(profile crawler.Profile) error {
+	return w.fanOut(func(sink Sink) (bool, error) {
+		if _, ok := sink.Written[profile.ID]; ok {
+			return false, nil
+		}
+
+		return true, sink.Writer.Write(profile)
+	})
+}
+
+// WriteEdge writes `edge` to all sinks which are crawler.EdgeWriters, see Write for the returned error
+func (w *MultiWriter) WriteEdge(edge crawler.Edge) error {
+	return w.fanOut(func(sink Sink) (bool, error) {
+		edgeWriter, ok := sink.Writer.(crawler.EdgeWriter)
+
+		if !ok {
+			return false, nil
+		}
+
+		return true, edgeWriter.WriteEdge(edge)
+	})
+}
+
+// Flush flushes all sinks which are crawler.Flushers, see Write for the returned error
+func (w *MultiWriter) Flush() error {
+	return w.fanOut(func(sink Sink) (bool, error) {
+		flusher, ok := sink.Writer.(crawler.Flusher)
+
+		if !ok {
+			return false, nil
+		}
+
+		return true, flusher.Flush()
+	})
+}
+
+// Close closes all sinks which are io.Closers, even after a sink failed fast.
+// It returns the first error, errors of best-effort sinks are only logged.
+func (w *MultiWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var firstErr error
+
+	for _, sink := range w.sinks {
+		closer, ok := sink.Writer.(io.Closer)
+
+		if !ok {
+			continue
+		}
+
+		err := closer.Close()
+
+		if err == nil {
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"sink":  sink.Name,
+			"error": err,
+		}).Error("closing sink failed")
+
+		if sink.Policy != BestEffort && firstErr == nil {
+			firstErr = &SinkError{Sink: sink.Name, Policy: sink.Policy, Err: err}
+		}
+	}
+
+	return firstErr
+}
+
+/* Private stuffs */
+
+var (
+	_ crawler.Writer     = (*MultiWriter)(nil)
+	_ crawler.EdgeWriter = (*MultiWriter)(nil)
+	_ crawler.Flusher    = (*MultiWriter)(nil)
+	_ io.Closer          = (*MultiWriter)(nil)
+)
+
+// fanOut calls `write` for every sink, which reports whether the sink supports the write and its error.
+// Sinks failing with the Retry policy are retried once all sinks were written, see retry.
+func (w *MultiWriter) fanOut(write func(Sink) (bool, error)) error {
+	w.mu.Lock()
+
+	if fatalErr := w.fatalErr; fatalErr != nil {
+		w.mu.Unlock()
+		return fatalErr
+	}
+
+	failedSinks := []Sink{}
+	failures := []error{}
+
+	for _, sink := range w.sinks {
+		supported, err := write(sink)
+
+		if !supported || err == nil {
+			continue
+		}
+
+		if sink.Policy == FailFast {
+			sinkErr := &SinkError{Sink: sink.Name, Policy: sink.Policy, Err: err}
+			w.fatalErr = sinkErr
+			w.mu.Unlock()
+
+			return sinkErr
+		}
+
+		failedSinks = append(failedSinks, sink)
+		failures = append(failures, err)
+	}
+
+	w.mu.Unlock()
+
+	return w.retry(failedSinks, failures, write)
+}
+
+// retry retries the failed sinks with the Retry policy concurrently, each one with its own backoff,
+// then reports the failures of best-effort sinks and returns the first sink which failed its retries.
+// `mu` isn't held while waiting, so that a slow sink doesn't stall the writes to the other ones.
+func (w *MultiWriter) retry(sinks []Sink, failures []error, write func(Sink) (bool, error)) error {
+	wg := sync.WaitGroup{}
+
+	for idx, sink := range sinks {
+		if sink.Policy != Retry {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(idx int, sink Sink) {
+			defer wg.Done()
+			failures[idx] = w.retrySink(sink, failures[idx], write)
+		}(idx, sink)
+	}
+
+	wg.Wait()
+
+	var firstErr error
+
+	for idx, sink := range sinks {
+		err := failures[idx]
+
+		switch {
+		case err == nil:
+		case sink.Policy == BestEffort:
+			logrus.WithFields(logrus.Fields{
+				"sink":  sink.Name,
+				"error": err,
+			}).Warn("writing to best-effort sink failed, ignoring")
+
+		case firstErr == nil:
+			firstErr = &SinkError{Sink: sink.Name, Policy: sink.Policy, Err: err}
+
+		default:
+			logrus.WithFields(logrus.Fields{
+				"sink":  sink.Name,
+				"error": err,
+			}).Error("writing to sink failed")
+		}
+	}
+
+	return firstErr
+}
+
+// retrySink writes to the sink again with an exponential backoff, up to its max attempts.
+// It gives up as soon as another sink failed fast, since no sink is written anymore then.
+func (w *MultiWriter) retrySink(sink Sink, err error, write func(Sink) (bool, error)) error {
+	delay := sink.RetryDelay
+
+	for attempt := 2; attempt <= sink.MaxAttempts; attempt++ {
+		logrus.WithFields(logrus.Fields{
+			"sink":    sink.Name,
+			"attempt": attempt - 1,
+			"delay":   delay,
+			"error":   err,
+		}).Warn("writing to sink failed, retrying")
+
+		time.Sleep(delay)
+		delay *= 2
+
+		w.mu.Lock()
+
+		if w.fatalErr != nil {
+			w.mu.Unlock()
+			return err
+		}
+
+		_, err = write(sink)
+		w.mu.Unlock()
+
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
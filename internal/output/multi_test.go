@@ -0,0 +1,181 @@
+package output
+
+import (
+	"errors"
+	"nsfw/internal/crawler"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseErrorPolicy(t *testing.T) {
+	policy, err := ParseErrorPolicy("best-effort")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, BestEffort, policy)
+
+	_, err = ParseErrorPolicy("ignore")
+	assert.EqualError(t, err, `unknown error policy "ignore", expected fail-fast, best-effort or retry`)
+}
+
+func TestMultiWriter(t *testing.T) {
+	profiles, edges := &failingWriter{}, &failingWriter{}
+	writer := NewMultiWriter(
+		Sink{Name: "profiles", Writer: profiles},
+		Sink{Name: "edges", Writer: &failingEdgeWriter{edges}},
+	)
+
+	edge := crawler.Edge{From: fakeProfile, To: crawler.Profile{ID: "2"}}
+
+	// Edges are only written to edge writers
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.WriteEdge(edge))
+	assert.Equal(t, 1, profiles.calls)
+	assert.Equal(t, 2, edges.calls)
+}
+
+func TestMultiWriterWritten(t *testing.T) {
+	appended, fresh := &failingWriter{}, &failingWriter{}
+	writer := NewMultiWriter(
+		Sink{Name: "appended", Writer: appended, Written: map[string]struct{}{"1": {}}},
+		Sink{Name: "fresh", Writer: fresh},
+	)
+
+	// Profiles already in an output aren't written to it again
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.Write(crawler.Profile{ID: "2"}))
+	assert.Equal(t, 1, appended.calls)
+	assert.Equal(t, 2, fresh.calls)
+}
+
+func TestMultiWriterFailFast(t *testing.T) {
+	failing, next := &failingWriter{failures: 1}, &failingWriter{}
+	writer := NewMultiWriter(
+		Sink{Name: "failing", Writer: failing},
+		Sink{Name: "next", Writer: next},
+	)
+
+	// The following sinks are skipped, and the failure is sticky
+	err := writer.Write(fakeProfile)
+	assert.EqualError(t, err, "sink failing: write failed")
+	assert.True(t, errors.Is(err, crawler.ErrFatalWrite))
+	assert.Equal(t, err, writer.Write(fakeProfile))
+	assert.Equal(t, 1, failing.calls)
+	assert.Equal(t, 0, next.calls)
+}
+
+func TestMultiWriterBestEffort(t *testing.T) {
+	failing, next := &failingWriter{failures: 1}, &failingWriter{}
+	writer := NewMultiWriter(
+		Sink{Name: "failing", Writer: failing, Policy: BestEffort},
+		Sink{Name: "next", Writer: next},
+	)
+
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, 2, failing.calls)
+	assert.Equal(t, 2, next.calls)
+}
+
+func TestMultiWriterRetry(t *testing.T) {
+	flaky, broken, next := &failingWriter{failures: 2}, &failingWriter{failures: 10}, &failingWriter{}
+	writer := NewMultiWriter(
+		Sink{Name: "flaky", Writer: flaky, Policy: Retry, RetryDelay: time.Millisecond},
+		Sink{Name: "broken", Writer: broken, Policy: Retry, MaxAttempts: 2, RetryDelay: time.Millisecond},
+		Sink{Name: "next", Writer: next},
+	)
+
+	// Exhausted retries are reported without being fatal, nor skipping the following sinks
+	err := writer.Write(fakeProfile)
+	assert.EqualError(t, err, "sink broken: write failed")
+	assert.False(t, errors.Is(err, crawler.ErrFatalWrite))
+	assert.Equal(t, 3, flaky.calls)
+	assert.Equal(t, 2, broken.calls)
+	assert.Equal(t, 1, next.calls)
+
+	// Later writes are attempted again
+	assert.EqualError(t, writer.Write(fakeProfile), "sink broken: write failed")
+	assert.Equal(t, 4, broken.calls)
+}
+
+func TestMultiWriterRetryBackoff(t *testing.T) {
+	broken, edges := &failingWriter{failures: 10}, &failingWriter{}
+	writer := NewMultiWriter(
+		Sink{Name: "broken", Writer: broken, Policy: Retry, RetryDelay: 50 * time.Millisecond},
+		Sink{Name: "edges", Writer: &failingEdgeWriter{edges}, Policy: BestEffort},
+	)
+
+	done := make(chan error)
+	go func() { done <- writer.Write(fakeProfile) }()
+
+	// The other sinks are written while the broken one backs off
+	time.Sleep(10 * time.Millisecond)
+	startTime := time.Now()
+
+	assert.Equal(t, nil, writer.WriteEdge(crawler.Edge{From: fakeProfile, To: crawler.Profile{ID: "2"}}))
+	assert.Less(t, time.Since(startTime), 20*time.Millisecond)
+	assert.EqualError(t, <-done, "sink broken: write failed")
+	assert.Equal(t, 3, broken.calls)
+	assert.Equal(t, 2, edges.calls)
+}
+
+func TestMultiWriterLifecycle(t *testing.T) {
+	csvFile, jsonFile := &mockFile{}, &mockFile{}
+	csvWriter := NewCSVWriter(csvFile, CSVConfig{})
+	writer := NewMultiWriter(
+		Sink{Name: "csv", Writer: csvWriter},
+		Sink{Name: "jsonl", Writer: NewJSONLinesWriter(jsonFile)},
+		Sink{Name: "plain", Writer: &failingWriter{}},
+	)
+
+	// Only flushers are flushed, only closers are closed
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, 0, csvFile.writes)
+	assert.Equal(t, nil, writer.Flush())
+	assert.Equal(t, 1, csvFile.writes)
+
+	assert.Equal(t, nil, writer.Close())
+	assert.Equal(t, true, csvFile.closed)
+	assert.Equal(t, true, jsonFile.closed)
+
+	// Sinks are closed even after a sink failed fast
+	jsonFile = &mockFile{}
+	writer = NewMultiWriter(
+		Sink{Name: "failing", Writer: &failingWriter{failures: 1}},
+		Sink{Name: "jsonl", Writer: NewJSONLinesWriter(jsonFile)},
+	)
+
+	assert.NotEqual(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.Close())
+	assert.Equal(t, true, jsonFile.closed)
+}
+
+/* Private stuffs */
+
+// failingWriter fails its first `failures` writes
+type failingWriter struct {
+	failures int
+	calls    int
+}
+
+func (w *failingWriter) Write(crawler.Profile) error {
+	return w.call()
+}
+
+func (w *failingWriter) call() error {
+	w.calls++
+
+	if w.calls <= w.failures {
+		return errors.New("write failed")
+	}
+
+	return nil
+}
+
+type failingEdgeWriter struct {
+	*failingWriter
+}
+
+func (w *failingEdgeWriter) WriteEdge(crawler.Edge) error {
+	return w.call()
+}
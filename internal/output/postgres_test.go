@@ -0,0 +1,178 @@
+package output
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"nsfw/internal/crawler"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValuesQuery(t *testing.T) {
+	query, args := valuesQuery("INSERT INTO t (a, b) VALUES ", " ON CONFLICT DO NOTHING", [][]interface{}{
+		{"1", 1},
+		{"2", 2},
+	})
+
+	assert.Equal(t, "INSERT INTO t (a, b) VALUES ($1, $2), ($3, $4) ON CONFLICT DO NOTHING", query)
+	assert.Equal(t, []interface{}{"1", 1, "2", 2}, args)
+}
+
+func TestOpenPostgresErrors(t *testing.T) {
+	_, err := OpenPostgres(context.Background(), PostgresConfig{})
+	assert.EqualError(t, err, "missing required DSN config")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err = OpenPostgres(ctx, PostgresConfig{DSN: "postgres://localhost:1/nsfw?sslmode=disable"})
+	assert.NotEqual(t, nil, err)
+}
+
+// The following tests run against a real database, e.g. the one started by `make test-postgres`
+func TestPostgresWriter(t *testing.T) {
+	db := openTestPostgres(t)
+
+	writer := NewPostgresWriter(db, PostgresConfig{BatchSize: 2, FlushInterval: time.Hour})
+	defer writer.Close()
+
+	// Nothing is written until the batch is full
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.WriteEdge(crawler.Edge{From: fakeProfile, To: crawler.Profile{ID: "2", Username: "user_2"}}))
+	assert.Equal(t, 0, countRows(t, db, "profiles"))
+
+	// The latest crawl of a profile wins within a batch
+	updatedProfile := fakeProfile
+	updatedProfile.DisplayName = "User One"
+	updatedProfile.Gallery = []string{"https://fake-photo-3"}
+	assert.Equal(t, nil, writer.Write(updatedProfile))
+
+	assert.Equal(t, 1, countRows(t, db, "profiles"))
+	assert.Equal(t, 1, countRows(t, db, "gallery"))
+
+	var displayName string
+	assert.Equal(t, nil, db.QueryRow(`SELECT display_name FROM profiles WHERE id = '1'`).Scan(&displayName))
+	assert.Equal(t, "User One", displayName)
+
+	// Upserted across batches, with duplicated edges ignored
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.WriteEdge(crawler.Edge{From: fakeProfile, To: crawler.Profile{ID: "2", Username: "user_2"}}))
+	assert.Equal(t, nil, writer.Flush())
+
+	assert.Equal(t, 1, countRows(t, db, "profiles"))
+	assert.Equal(t, 2, countRows(t, db, "gallery"))
+	assert.Equal(t, 1, countRows(t, db, "related"))
+}
+
+func TestPostgresWriterFlushInterval(t *testing.T) {
+	db := openTestPostgres(t)
+
+	writer := NewPostgresWriter(db, PostgresConfig{BatchSize: 100, FlushInterval: 10 * time.Millisecond})
+	defer writer.Close()
+
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, 1, countRows(t, db, "profiles"))
+}
+
+func TestPostgresWriterFailedFlush(t *testing.T) {
+	db := openTestPostgres(t)
+
+	writer := NewPostgresWriter(db, PostgresConfig{BatchSize: 100, FlushInterval: time.Hour})
+	defer writer.Close()
+
+	_, err := db.Exec(`ALTER TABLE profiles RENAME TO profiles_away`)
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.NotEqual(t, nil, writer.Flush())
+
+	// The failed batch is kept, and written by the next flush
+	_, err = db.Exec(`ALTER TABLE profiles_away RENAME TO profiles`)
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, nil, writer.Flush())
+	assert.Equal(t, 1, countRows(t, db, "profiles"))
+	assert.Equal(t, 2, countRows(t, db, "gallery"))
+
+	// A batch which never succeeds is dropped after a few attempts, instead of blocking the following ones
+	poisonProfile := crawler.Profile{ID: "2", Username: "user\x002"}
+	assert.Equal(t, nil, writer.Write(poisonProfile))
+
+	for attempt := 1; attempt < postgresMaxFlushAttempts; attempt++ {
+		assert.NotEqual(t, nil, writer.Flush())
+	}
+
+	err = writer.Flush()
+	assert.NotEqual(t, nil, err)
+	assert.Contains(t, fmt.Sprint(err), "dropped 1 profiles and 0 edges after 3 failed flushes")
+	assert.Equal(t, 0, len(writer.profiles))
+
+	updatedProfile := fakeProfile
+	updatedProfile.DisplayName = "User One"
+	assert.Equal(t, nil, writer.Write(updatedProfile))
+	assert.Equal(t, nil, writer.Flush())
+	assert.Equal(t, 1, countRows(t, db, "profiles"))
+}
+
+func TestPostgresWriterRetriedWrites(t *testing.T) {
+	// The database is only reached by flushes
+	db, err := sql.Open("postgres", "postgres://localhost:1/nsfw?sslmode=disable")
+	assert.Equal(t, nil, err)
+
+	writer := NewPostgresWriter(db, PostgresConfig{BatchSize: 100, FlushInterval: time.Hour})
+	defer writer.Close()
+
+	edge := crawler.Edge{From: fakeProfile, To: crawler.Profile{ID: "2", Username: "user_2"}}
+
+	// Writes retried after a failed flush replace their buffered row
+	updatedProfile := fakeProfile
+	updatedProfile.DisplayName = "User One"
+
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.Write(updatedProfile))
+	assert.Equal(t, nil, writer.WriteEdge(edge))
+	assert.Equal(t, nil, writer.WriteEdge(edge))
+
+	assert.Equal(t, []crawler.Profile{updatedProfile}, writer.profiles)
+	assert.Equal(t, []crawler.Edge{edge}, writer.edges)
+}
+
+/* Private stuffs */
+
+// openTestPostgres connects to `POSTGRES_TEST_DSN` and resets the schema, or skips the test if unset
+func openTestPostgres(t *testing.T) *sql.DB {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+
+	db, err := OpenPostgres(context.Background(), PostgresConfig{DSN: dsn})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(`DROP TABLE IF EXISTS schema_migrations, related, gallery, profiles`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := MigratePostgres(context.Background(), db)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(postgresMigrations), version)
+
+	// Migrating again is a no-op
+	version, err = MigratePostgres(context.Background(), db)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(postgresMigrations), version)
+
+	return db
+}
@@ -0,0 +1,194 @@
+package output
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"nsfw/internal/crawler"
+	"time"
+
+	// Registers the pure Go `sqlite` driver, so that no cgo toolchain is needed
+	_ "modernc.org/sqlite"
+)
+
+// NewSQLiteWriter opens the SQLite database at `path`, creating it and its tables if needed.
+// Profiles are upserted on their ID, so that crawling a profile again updates its rows.
+func NewSQLiteWriter(path string) (*SQLiteWriter, error) {
+	// Pragmas of the DSN are applied to every connection of the pool
+	db, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)")
+
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, sharing one connection avoids `database is locked` errors
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating %s: %w", path, err)
+	}
+
+	return &SQLiteWriter{db: db}, nil
+}
+
+// SQLiteWriter writes profiles, their gallery and their related edges to an embedded SQLite database:
+// - profiles: one row per profile ID
+// - gallery: one row per gallery URL, keyed by profile ID and position
+// - related: one row per `from_id` profile suggesting the `to_id` profile, with the depth of `from_id`
+type SQLiteWriter struct {
+	db *sql.DB
+}
+
+// Write upserts the profile and replaces its gallery, in a single transaction
+func (w *SQLiteWriter) Write(profile crawler.Profile) error {
+	if profile.ID == "" {
+		return errors.New("sqlite writer: profile has no ID")
+	}
+
+	tx, err := w.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO profiles (id, source, username, display_name, avatar_url, depth, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			source = excluded.source,
+			username = excluded.username,
+			display_name = excluded.display_name,
+			avatar_url = excluded.avatar_url,
+			depth = excluded.depth,
+			updated_at = excluded.updated_at`,
+		profile.ID, profile.Source, profile.Username, profile.DisplayName, profile.AvatarURL, profile.Depth,
+		time.Now().UTC(),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM gallery WHERE profile_id = ?`, profile.ID); err != nil {
+		return err
+	}
+
+	for position, url := range profile.Gallery {
+		_, err := tx.Exec(`INSERT INTO gallery (profile_id, position, url) VALUES (?, ?, ?)`, profile.ID, position, url)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// WriteEdge records that `edge.From` suggested `edge.To`, edges already recorded keep their first discovery
+func (w *SQLiteWriter) WriteEdge(edge crawler.Edge) error {
+	if edge.From.ID == "" || edge.To.ID == "" {
+		return errors.New("sqlite writer: edge profiles have no ID")
+	}
+
+	_, err := w.db.Exec(`
+		INSERT INTO related (from_id, to_id, to_username, depth, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (from_id, to_id) DO NOTHING`,
+		edge.From.ID, edge.To.ID, edge.To.Username, edge.Depth, edgeTimestamp(edge),
+	)
+
+	return err
+}
+
+// Close closes the database
+func (w *SQLiteWriter) Close() error {
+	return w.db.Close()
+}
+
+/* Private stuffs */
+
+var (
+	_ crawler.Writer     = (*SQLiteWriter)(nil)
+	_ crawler.EdgeWriter = (*SQLiteWriter)(nil)
+)
+
+// sqliteMigrations are applied in order, the version of a migration is its index + 1,
+// tracked by `PRAGMA user_version`. Never edit an applied migration, append a new one instead.
+// `related.to_id` isn't a foreign key, since suggested profiles may never be crawled.
+var sqliteMigrations = [][]string{
+	{
+		`CREATE TABLE IF NOT EXISTS profiles (
+			id TEXT PRIMARY KEY,
+			source TEXT NOT NULL,
+			username TEXT NOT NULL,
+			display_name TEXT NOT NULL,
+			avatar_url TEXT NOT NULL,
+			depth INTEGER NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS gallery (
+			profile_id TEXT NOT NULL REFERENCES profiles (id) ON DELETE CASCADE,
+			position INTEGER NOT NULL,
+			url TEXT NOT NULL,
+			PRIMARY KEY (profile_id, position)
+		)`,
+		`CREATE TABLE IF NOT EXISTS related (
+			from_id TEXT NOT NULL,
+			to_id TEXT NOT NULL,
+			to_username TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (from_id, to_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS related_to_id ON related (to_id)`,
+	},
+	{
+		`ALTER TABLE related ADD COLUMN depth INTEGER NOT NULL DEFAULT 0`,
+	},
+}
+
+func migrateSQLite(db *sql.DB) error {
+	version := 0
+
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := db.Begin()
+
+		if err != nil {
+			return err
+		}
+
+		for _, statement := range sqliteMigrations[version] {
+			if _, err := tx.Exec(statement); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d: %w", version+1, err)
+			}
+		}
+
+		// Pragmas don't support bind parameters
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// edgeTimestamp returns when the edge was discovered, or now if unknown
+func edgeTimestamp(edge crawler.Edge) time.Time {
+	if edge.Timestamp.IsZero() {
+		return time.Now().UTC()
+	}
+
+	return edge.Timestamp.UTC()
+}
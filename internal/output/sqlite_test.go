@@ -0,0 +1,106 @@
+package output
+
+import (
+	"database/sql"
+	"nsfw/internal/crawler"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.db")
+	writer, err := NewSQLiteWriter(path)
+	assert.Equal(t, nil, err)
+	defer writer.Close()
+
+	assert.Equal(t, nil, writer.Write(fakeProfile))
+	assert.Equal(t, nil, writer.WriteEdge(crawler.Edge{From: fakeProfile, To: crawler.Profile{ID: "2", Username: "user_2"}, Depth: 2}))
+	assert.Equal(t, nil, writer.WriteEdge(crawler.Edge{From: fakeProfile, To: crawler.Profile{ID: "2", Username: "user_2"}, Depth: 3}))
+
+	// Crawling the profile again updates its rows
+	updatedProfile := fakeProfile
+	updatedProfile.DisplayName = "User One"
+	updatedProfile.Gallery = []string{"https://fake-photo-3"}
+	assert.Equal(t, nil, writer.Write(updatedProfile))
+
+	db := writer.db
+
+	assert.Equal(t, 1, countRows(t, db, "profiles"))
+	assert.Equal(t, 1, countRows(t, db, "gallery"))
+	assert.Equal(t, 1, countRows(t, db, "related"))
+
+	var displayName, url, toUsername string
+	var depth int
+	assert.Equal(t, nil, db.QueryRow(`SELECT display_name FROM profiles WHERE id = '1'`).Scan(&displayName))
+	assert.Equal(t, nil, db.QueryRow(`SELECT url FROM gallery WHERE profile_id = '1' AND position = 0`).Scan(&url))
+	assert.Equal(t, nil, db.QueryRow(`SELECT to_username, depth FROM related WHERE from_id = '1' AND to_id = '2'`).Scan(&toUsername, &depth))
+	assert.Equal(t, "User One", displayName)
+	assert.Equal(t, "https://fake-photo-3", url)
+	assert.Equal(t, "user_2", toUsername)
+	assert.Equal(t, 2, depth)
+
+	// The schema is reused when reopening the database
+	assert.Equal(t, nil, writer.Close())
+
+	writer, err = NewSQLiteWriter(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, countRows(t, writer.db, "profiles"))
+}
+
+func TestSQLiteWriterMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.db")
+
+	// A database created before edges had a depth
+	db, err := sql.Open("sqlite", path)
+	assert.Equal(t, nil, err)
+
+	for _, statement := range sqliteMigrations[0] {
+		_, err := db.Exec(statement)
+		assert.Equal(t, nil, err)
+	}
+
+	_, err = db.Exec(`PRAGMA user_version = 1`)
+	assert.Equal(t, nil, err)
+	_, err = db.Exec(`INSERT INTO related (from_id, to_id, to_username, created_at) VALUES ('1', '2', 'user_2', '2022-01-01')`)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, db.Close())
+
+	writer, err := NewSQLiteWriter(path)
+	assert.Equal(t, nil, err)
+	defer writer.Close()
+
+	var version, depth int
+	assert.Equal(t, nil, writer.db.QueryRow(`PRAGMA user_version`).Scan(&version))
+	assert.Equal(t, nil, writer.db.QueryRow(`SELECT depth FROM related WHERE to_id = '2'`).Scan(&depth))
+	assert.Equal(t, len(sqliteMigrations), version)
+	assert.Equal(t, 0, depth)
+}
+
+func TestSQLiteWriterErrors(t *testing.T) {
+	_, err := NewSQLiteWriter(filepath.Join(t.TempDir(), "missing", "profiles.db"))
+	assert.NotEqual(t, nil, err)
+
+	writer, _ := NewSQLiteWriter(filepath.Join(t.TempDir(), "profiles.db"))
+	defer writer.Close()
+
+	assert.EqualError(t, writer.Write(crawler.Profile{Username: "user_1"}), "sqlite writer: profile has no ID")
+	assert.EqualError(t, writer.WriteEdge(crawler.Edge{From: fakeProfile}), "sqlite writer: edge profiles have no ID")
+
+	// Gallery rows can't outlive their profile
+	_, err = writer.db.Exec(`INSERT INTO gallery (profile_id, position, url) VALUES ('404', 0, 'https://fake-photo')`)
+	assert.Contains(t, err.Error(), "FOREIGN KEY constraint failed")
+}
+
+/* Private stuffs */
+
+func countRows(t *testing.T, db *sql.DB, table string) int {
+	var count int
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	return count
+}
@@ -0,0 +1,14 @@
+#!/bin/sh
+# Waits until the postgres container of docker-compose accepts connections
+set -e
+
+for attempt in $(seq 1 30); do
+	if docker-compose --file deployments/docker-compose.yml --project-name nsfw exec -T postgres pg_isready --username nsfw >/dev/null 2>&1; then
+		exit 0
+	fi
+
+	sleep 1
+done
+
+echo "postgres is not ready after 30s" >&2
+exit 1